@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	envClientID     = "MODICA_CLIENT_ID"
+	envClientSecret = "MODICA_CLIENT_SECRET"
+	envBaseURL      = "MODICA_BASE_URL"
+	envConfig       = "MODICA_CONFIG"
+)
+
+// errMissingCredentials is returned when no client credentials could be
+// found from any source.
+var errMissingCredentials = errors.New("client credentials are required, set them with -client-id and -client-secret, " +
+	envClientID + " and " + envClientSecret + ", or a config file")
+
+// config contains the settings needed to talk to the Modica API.
+type config struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	BaseURL      string `json:"base_url,omitempty"`
+}
+
+// globalFlags contains the flags shared by every subcommand.
+type globalFlags struct {
+	config
+	configPath string
+	json       bool
+}
+
+// register adds the global flags to fs.
+func (g *globalFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.ClientID, "client-id", "", "Modica client ID (application name)")
+	fs.StringVar(&g.ClientSecret, "client-secret", "", "Modica client secret")
+	fs.StringVar(&g.BaseURL, "base-url", "", "Modica API base URL, with a trailing slash")
+	fs.StringVar(&g.configPath, "config", "", "path to a JSON config file")
+	fs.BoolVar(&g.json, "json", false, "output results as JSON")
+}
+
+// resolve merges the settings from flags, environment variables and the
+// config file, in that order of precedence.
+func (g *globalFlags) resolve(getenv func(string) string) (config, error) {
+	path := g.configPath
+	explicit := path != ""
+	if !explicit {
+		path = getenv(envConfig)
+		explicit = path != ""
+	}
+	if !explicit {
+		path = defaultConfigPath(getenv)
+	}
+
+	var cfg config
+	if path != "" {
+		fileCfg, err := loadConfig(path)
+		switch {
+		case err == nil:
+			cfg = fileCfg
+		case !os.IsNotExist(err) || explicit:
+			return cfg, err
+		}
+	}
+
+	overlay(&cfg.ClientID, getenv(envClientID))
+	overlay(&cfg.ClientSecret, getenv(envClientSecret))
+	overlay(&cfg.BaseURL, getenv(envBaseURL))
+
+	overlay(&cfg.ClientID, g.ClientID)
+	overlay(&cfg.ClientSecret, g.ClientSecret)
+	overlay(&cfg.BaseURL, g.BaseURL)
+
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return cfg, errMissingCredentials
+	}
+
+	return cfg, nil
+}
+
+// overlay replaces dst with value, if value is set.
+func overlay(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+// defaultConfigPath returns the path of the user's config file.
+func defaultConfigPath(getenv func(string) string) string {
+	if dir := getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "modica", "config.json")
+	}
+	if home := getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config", "modica", "config.json")
+	}
+
+	return ""
+}
+
+// loadConfig reads a JSON config file.
+func loadConfig(path string) (config, error) {
+	var cfg config
+
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return cfg, nil
+}
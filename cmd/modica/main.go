@@ -0,0 +1,431 @@
+// Command modica sends and inspects messages through Modica's Mobile Gateway
+// API.
+//
+// Usage:
+//
+//	modica send -destination +642123456789 -content "Hello"
+//	modica broadcast -destination +642123456789 -destination +64987654321 -content "Hello"
+//	modica get -id 123456
+//
+// Credentials are read from the -client-id and -client-secret flags, the
+// MODICA_CLIENT_ID and MODICA_CLIENT_SECRET environment variables, or a JSON
+// config file, in that order of precedence. The config file defaults to
+// $XDG_CONFIG_HOME/modica/config.json, and can be set with -config or
+// MODICA_CONFIG:
+//
+//	{"client_id": "my-application", "client_secret": "s3cr3t"}
+//
+// The exit status reports the kind of failure, so scripts can react to
+// specific gateway errors:
+//
+//	0   success
+//	1   other error
+//	2   invalid usage
+//	3   unauthorized
+//	4   message not found
+//	10  send_failed
+//	11  invalid_json
+//	12  missing_attrib
+//	13  invalid_attrib
+//	14  broadcast_limit
+//	15  invalid scheduled timestamp format
+//	16  scheduled timestamp in the past
+//	17  message ID missing from the response
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matthewhartstonge/go-modica"
+)
+
+// Exit codes returned by the command.
+const (
+	exitOK                  = 0
+	exitError               = 1
+	exitUsage               = 2
+	exitUnauthorized        = 3
+	exitNotFound            = 4
+	exitSendFailed          = 10
+	exitInvalidJSON         = 11
+	exitMissingAttribute    = 12
+	exitInvalidAttribute    = 13
+	exitBroadcastLimit      = 14
+	exitInvalidTimestampFmt = 15
+	exitInvalidTimestamp    = 16
+	exitMessageIDNotFound   = 17
+)
+
+// exitCodes maps API errors to the exit status reported for them.
+var exitCodes = map[error]int{
+	modica.ErrUnauthorized:                        exitUnauthorized,
+	modica.ErrNotFound:                            exitNotFound,
+	modica.ErrMobileGatewaySendFailed:             exitSendFailed,
+	modica.ErrMobileGatewayInvalidJSON:            exitInvalidJSON,
+	modica.ErrMobileGatewayMissingAttribute:       exitMissingAttribute,
+	modica.ErrMobileGatewayInvalidAttribute:       exitInvalidAttribute,
+	modica.ErrMobileGatewayBroadcastLimit:         exitBroadcastLimit,
+	modica.ErrMobileGatewayInvalidTimestampFormat: exitInvalidTimestampFmt,
+	modica.ErrMobileGatewayInvalidTimestamp:       exitInvalidTimestamp,
+	modica.ErrMobileGatewayMessageIDNotFound:      exitMessageIDNotFound,
+}
+
+// usageError reports that the command was invoked incorrectly.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+// command contains the context a subcommand runs with.
+type command struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+
+	// newClient enables swapping the API client in tests.
+	newClient func(cfg config) (*modica.Client, error)
+}
+
+func main() {
+	cmd := &command{
+		stdin:     os.Stdin,
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+		getenv:    os.Getenv,
+		newClient: newClient,
+	}
+	os.Exit(cmd.run(os.Args[1:]))
+}
+
+// newClient builds an API client from the resolved configuration.
+func newClient(cfg config) (*modica.Client, error) {
+	var opts []modica.ClientOption
+	if cfg.BaseURL != "" {
+		opts = append(opts, modica.WithBaseURL(cfg.BaseURL))
+	}
+
+	return modica.NewClientWithOptions(cfg.ClientID, cfg.ClientSecret, opts...)
+}
+
+const usage = `Usage: modica <command> [flags]
+
+Commands:
+  send       send a message to a single destination
+  broadcast  send a message to multiple destinations
+  get        retrieve a message by ID
+
+Run 'modica <command> -h' for the flags of each command.
+`
+
+// run executes the command line, returning the process exit status.
+func (c *command) run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(c.stderr, usage)
+		return exitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "send":
+		err = c.send(args[1:])
+	case "broadcast":
+		err = c.broadcast(args[1:])
+	case "get":
+		err = c.get(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(c.stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(c.stderr, "modica: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	if err == nil {
+		return exitOK
+	}
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+
+	fmt.Fprintf(c.stderr, "modica: %v\n", err)
+	return exitCode(err)
+}
+
+// exitCode returns the exit status for err.
+func exitCode(err error) int {
+	if _, ok := err.(usageError); ok {
+		return exitUsage
+	}
+	for target, code := range exitCodes {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+
+	return exitError
+}
+
+// messageFlags contains the flags used to build a message.
+type messageFlags struct {
+	content   string
+	source    string
+	class     string
+	scheduled string
+	reference string
+	mask      string
+	smsClass  int
+}
+
+func (m *messageFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&m.content, "content", "", "message text, or - to read it from stdin")
+	fs.StringVar(&m.source, "source", "", "source short code or number (can't be used with -class)")
+	fs.StringVar(&m.class, "class", "", "message class, e.g. mt_message (can't be used with -source)")
+	fs.StringVar(&m.scheduled, "scheduled", "", "RFC3339 time to send the message at")
+	fs.StringVar(&m.reference, "reference", "", "reference to attach to the message")
+	fs.StringVar(&m.mask, "mask", "", "sender mask")
+	fs.IntVar(&m.smsClass, "sms-class", 0, "SMS class, between 1 and 3")
+}
+
+// message builds a Message from the flags, reading the content from stdin if
+// requested.
+func (m *messageFlags) message(stdin io.Reader) (modica.Message, error) {
+	content := m.content
+	if content == "-" {
+		b, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return modica.Message{}, err
+		}
+		content = strings.TrimRight(string(b), "\r\n")
+	}
+	if content == "" {
+		return modica.Message{}, usageError{"-content is required"}
+	}
+	if m.source != "" && m.class != "" {
+		return modica.Message{}, usageError{"only one of -source or -class may be provided"}
+	}
+	if m.smsClass < 0 || m.smsClass > 3 {
+		return modica.Message{}, usageError{"-sms-class must be between 1 and 3"}
+	}
+
+	return modica.Message{
+		Content:   content,
+		Source:    m.source,
+		Class:     m.class,
+		Scheduled: m.scheduled,
+		Reference: m.reference,
+		Mask:      m.mask,
+		SMSClass:  m.smsClass,
+	}, nil
+}
+
+// stringSlice implements flag.Value for flags that can be repeated or given
+// as a comma separated list.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
+	}
+	return nil
+}
+
+// setup parses the flags in fs, checks them with validate and builds an API
+// client. Flags are validated before credentials are resolved, so a usage
+// error is reported as one even when no credentials are configured.
+func (c *command) setup(fs *flag.FlagSet, global *globalFlags, args []string, validate func() error) (*modica.Client, context.Context, context.CancelFunc, error) {
+	timeout := fs.Duration("timeout", 30*time.Second, "maximum time to wait for the API")
+	fs.SetOutput(c.stderr)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, nil, nil, err
+		}
+		return nil, nil, nil, usageError{err.Error()}
+	}
+	if fs.NArg() > 0 {
+		return nil, nil, nil, usageError{fmt.Sprintf("unexpected arguments: %s", strings.Join(fs.Args(), " "))}
+	}
+	if err := validate(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	cfg, err := global.resolve(c.getenv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	client, err := c.newClient(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	return client, ctx, cancel, nil
+}
+
+func (c *command) send(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	var global globalFlags
+	var msg messageFlags
+	var destination string
+	global.register(fs)
+	msg.register(fs)
+	fs.StringVar(&destination, "destination", "", "international format destination number, e.g. +642123456789")
+
+	var message modica.Message
+	client, ctx, cancel, err := c.setup(fs, &global, args, func() (err error) {
+		if destination == "" {
+			return usageError{"-destination is required"}
+		}
+		message, err = msg.message(c.stdin)
+		message.Destination = destination
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	messageID, err := client.MobileGateway.CreateMessageContext(ctx, &message)
+	if err != nil {
+		return err
+	}
+
+	if global.json {
+		return c.writeJSON(map[string]int{"id": messageID})
+	}
+
+	fmt.Fprintf(c.stdout, "Message %d created\n", messageID)
+	return nil
+}
+
+func (c *command) broadcast(args []string) error {
+	fs := flag.NewFlagSet("broadcast", flag.ContinueOnError)
+	var global globalFlags
+	var msg messageFlags
+	var destinations stringSlice
+	global.register(fs)
+	msg.register(fs)
+	fs.Var(&destinations, "destination", "international format destination number, repeat or comma separate for multiple")
+
+	var message modica.Message
+	client, ctx, cancel, err := c.setup(fs, &global, args, func() (err error) {
+		if len(destinations) == 0 {
+			return usageError{"at least one -destination is required"}
+		}
+		message, err = msg.message(c.stdin)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	responses, err := client.MobileGateway.CreateBroadcastMessageContext(ctx, &modica.BroadcastMessage{
+		Destinations: destinations,
+		Message:      message,
+	})
+	if err != nil {
+		return err
+	}
+
+	if global.json {
+		return c.writeJSON(responses)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DESTINATION\tSTATUS\tID\tMESSAGE")
+	for _, res := range responses {
+		id := "-"
+		if res.ID != 0 {
+			id = fmt.Sprint(res.ID)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Destination, res.Status, id, res.Message)
+	}
+	return tw.Flush()
+}
+
+func (c *command) get(args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	var global globalFlags
+	var messageID int
+	global.register(fs)
+	fs.IntVar(&messageID, "id", 0, "ID of the message to retrieve")
+
+	client, ctx, cancel, err := c.setup(fs, &global, args, func() error {
+		if messageID <= 0 {
+			return usageError{"-id is required"}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	message, err := client.MobileGateway.GetMessageContext(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	if global.json {
+		return c.writeJSON(message)
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"ID", fmt.Sprint(message.ID)},
+		{"Status", string(message.Status)},
+		{"Destination", message.Destination},
+		{"Source", message.Source},
+		{"Class", message.Class},
+		{"Scheduled", message.Scheduled},
+		{"Reference", message.Reference},
+		{"Mask", message.Mask},
+		{"SMS Class", smsClassString(message.SMSClass)},
+		{"Reply To", message.ReplyTo},
+		{"Operator", message.Operator},
+		{"Content", message.Content},
+	}
+	for _, field := range fields {
+		if field.value != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", field.name, field.value)
+		}
+	}
+	return tw.Flush()
+}
+
+func smsClassString(smsClass int) string {
+	if smsClass == 0 {
+		return ""
+	}
+	return fmt.Sprint(smsClass)
+}
+
+func (c *command) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewhartstonge/go-modica"
+	"github.com/matthewhartstonge/go-modica/modicatest"
+)
+
+// testCommand returns a command wired up to a fake gateway.
+func testCommand(server *modicatest.Server, env map[string]string) (*command, *bytes.Buffer, *bytes.Buffer) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	cmd := &command{
+		stdin:  strings.NewReader("Hello from stdin\n"),
+		stdout: stdout,
+		stderr: stderr,
+		getenv: func(key string) string {
+			return env[key]
+		},
+		newClient: func(cfg config) (*modica.Client, error) {
+			return server.Client(), nil
+		},
+	}
+
+	return cmd, stdout, stderr
+}
+
+var testEnv = map[string]string{
+	envClientID:     "foo",
+	envClientSecret: "bar",
+}
+
+func TestCommand_Send(t *testing.T) {
+	server := modicatest.NewServer()
+	defer server.Close()
+	cmd, stdout, stderr := testCommand(server, testEnv)
+
+	code := cmd.run([]string{"send", "-destination", "+642123456789", "-content", "-", "-reference", "ref-1", "-sms-class", "2"})
+	if code != exitOK {
+		t.Fatalf("send exited with %d, want %d: %s", code, exitOK, stderr)
+	}
+	if got, want := stdout.String(), "Message 1 created\n"; got != want {
+		t.Errorf("send output %q, want %q", got, want)
+	}
+
+	message, _ := server.Message(1)
+	if message.Content != "Hello from stdin" || message.Reference != "ref-1" || message.SMSClass != 2 {
+		t.Errorf("send created %+v", message)
+	}
+}
+
+func TestCommand_Broadcast_JSON(t *testing.T) {
+	server := modicatest.NewServer()
+	defer server.Close()
+	cmd, stdout, stderr := testCommand(server, testEnv)
+
+	code := cmd.run([]string{"broadcast", "-json", "-destination", "+642123456789,+64987654321", "-destination", "X", "-content", "Hello"})
+	if code != exitOK {
+		t.Fatalf("broadcast exited with %d, want %d: %s", code, exitOK, stderr)
+	}
+
+	var got []modica.BroadcastResponse
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("broadcast output isn't valid JSON: %v", err)
+	}
+	if len(got) != 3 || got[2].Status != "failure" {
+		t.Errorf("broadcast output %+v", got)
+	}
+}
+
+func TestCommand_Get(t *testing.T) {
+	server := modicatest.NewServer()
+	defer server.Close()
+	cmd, stdout, stderr := testCommand(server, testEnv)
+
+	server.Client().MobileGateway.CreateMessage(&modica.Message{Destination: "+642123456789", Content: "Hello"})
+	server.SetStatus(1, modica.MessageStatusDead)
+
+	code := cmd.run([]string{"get", "-id", "1"})
+	if code != exitOK {
+		t.Fatalf("get exited with %d, want %d: %s", code, exitOK, stderr)
+	}
+	if !strings.Contains(stdout.String(), "+642123456789") {
+		t.Errorf("get output %q doesn't contain the destination", stdout)
+	}
+	if !strings.Contains(stdout.String(), "Status:") || !strings.Contains(stdout.String(), "dead") {
+		t.Errorf("get output %q doesn't contain the status", stdout)
+	}
+}
+
+func TestCommand_ExitCodes(t *testing.T) {
+	server := modicatest.NewServer()
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want int
+	}{
+		{
+			name: "no command",
+			env:  testEnv,
+			want: exitUsage,
+		},
+		{
+			name: "unknown command",
+			env:  testEnv,
+			args: []string{"frobnicate"},
+			want: exitUsage,
+		},
+		{
+			name: "missing content",
+			env:  testEnv,
+			args: []string{"send", "-destination", "+642123456789"},
+			want: exitUsage,
+		},
+		{
+			name: "missing credentials",
+			args: []string{"get", "-id", "1"},
+			want: exitError,
+		},
+		{
+			name: "missing destination without credentials",
+			args: []string{"send", "-content", "Hello"},
+			want: exitUsage,
+		},
+		{
+			name: "missing broadcast destination without credentials",
+			args: []string{"broadcast", "-content", "Hello"},
+			want: exitUsage,
+		},
+		{
+			name: "missing id without credentials",
+			args: []string{"get"},
+			want: exitUsage,
+		},
+		{
+			name: "not found",
+			env:  testEnv,
+			args: []string{"get", "-id", "321"},
+			want: exitNotFound,
+		},
+		{
+			name: "invalid attribute",
+			env:  testEnv,
+			args: []string{"send", "-destination", "LOL", "-content", "Hello"},
+			want: exitInvalidAttribute,
+		},
+		{
+			name: "invalid timestamp format",
+			env:  testEnv,
+			args: []string{"send", "-destination", "+642123456789", "-content", "Hello", "-scheduled", "tomorrow"},
+			want: exitInvalidTimestampFmt,
+		},
+	}
+	for _, test := range tests {
+		cmd, _, _ := testCommand(server, test.env)
+		if got := cmd.run(test.args); got != test.want {
+			t.Errorf("%s: exited with %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGlobalFlags_Resolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(path, []byte(`{"client_id":"file-id","client_secret":"file-secret","base_url":"https://file.example.com/"}`), 0600)
+
+	global := globalFlags{configPath: path}
+	global.ClientSecret = "flag-secret"
+	env := map[string]string{
+		envClientID:     "env-id",
+		envClientSecret: "env-secret",
+	}
+
+	got, err := global.resolve(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatalf("globalFlags.resolve returned error: %v", err)
+	}
+
+	want := config{
+		ClientID:     "env-id",
+		ClientSecret: "flag-secret",
+		BaseURL:      "https://file.example.com/",
+	}
+	if got != want {
+		t.Errorf("globalFlags.resolve returned %+v, want %+v", got, want)
+	}
+
+	global = globalFlags{configPath: filepath.Join(dir, "missing.json")}
+	if _, err := global.resolve(func(key string) string { return env[key] }); err == nil {
+		t.Error("globalFlags.resolve should fail when an explicit config file is missing")
+	}
+}
@@ -0,0 +1,347 @@
+package modica
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Direction describes which way a message in a conversation travelled.
+type Direction string
+
+const (
+	// DirectionOutbound marks a message sent through the client.
+	DirectionOutbound Direction = "outbound"
+
+	// DirectionInbound marks a message received from a recipient.
+	DirectionInbound Direction = "inbound"
+)
+
+// ConversationEntry records a message in a conversation with a phone number.
+type ConversationEntry struct {
+	Direction Direction
+
+	// MessageID contains the gateway's ID for the message.
+	MessageID int
+
+	// Number contains the phone number of the other party to the
+	// conversation.
+	Number string
+
+	Content string
+
+	// InReplyTo contains the ID of the outbound message an inbound message
+	// was matched to as a reply, if any.
+	InReplyTo int
+
+	// Metadata contains the caller's details recorded with an outbound
+	// message. Inbound replies carry the metadata of the message they were
+	// matched to.
+	Metadata map[string]string
+
+	At time.Time
+}
+
+const (
+	// DefaultConversationMaxAge contains how long messages are kept in a
+	// conversation store by default.
+	DefaultConversationMaxAge = 30 * 24 * time.Hour
+
+	// DefaultConversationMaxThreads contains the number of phone numbers
+	// a conversation store keeps threads for by default.
+	DefaultConversationMaxThreads = 10000
+
+	// DefaultConversationMaxThreadLength contains the number of messages
+	// kept in each thread by default.
+	DefaultConversationMaxThreadLength = 100
+)
+
+// ConversationOptions configures how much a conversation store keeps.
+type ConversationOptions struct {
+	// MaxAge contains how long messages are kept. Older messages are
+	// dropped, and replies are no longer matched to them. Defaults to
+	// DefaultConversationMaxAge.
+	MaxAge time.Duration
+
+	// MaxThreads contains the number of phone numbers threads are kept for.
+	// Once exceeded, the thread with the oldest activity is dropped.
+	// Defaults to DefaultConversationMaxThreads.
+	MaxThreads int
+
+	// MaxThreadLength contains the number of messages kept in each thread.
+	// Once exceeded, the oldest messages are dropped. Defaults to
+	// DefaultConversationMaxThreadLength.
+	MaxThreadLength int
+
+	// Region contains the region national numbers are interpreted as
+	// belonging to, so a number is threaded however it is written.
+	// Defaults to RegionNZ.
+	Region Region
+}
+
+// ConversationStore threads outbound messages with the inbound replies they
+// prompt, keeping recent conversations with each phone number in memory. It
+// is safe for concurrent use.
+type ConversationStore struct {
+	opts ConversationOptions
+
+	mu       sync.Mutex
+	threads  map[string]*conversationThread
+	order    *list.List
+	outbound map[int]ConversationEntry
+	now      func() time.Time
+}
+
+// conversationThread holds the messages exchanged with a number, along with
+// its place in the store's order of recent activity.
+type conversationThread struct {
+	number  string
+	entries []ConversationEntry
+	elem    *list.Element
+}
+
+// NewConversationStore returns an empty conversation store.
+func NewConversationStore(opts ConversationOptions) *ConversationStore {
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultConversationMaxAge
+	}
+	if opts.MaxThreads <= 0 {
+		opts.MaxThreads = DefaultConversationMaxThreads
+	}
+	if opts.MaxThreadLength <= 0 {
+		opts.MaxThreadLength = DefaultConversationMaxThreadLength
+	}
+	if opts.Region == "" {
+		opts.Region = RegionNZ
+	}
+
+	return &ConversationStore{
+		opts:     opts,
+		threads:  make(map[string]*conversationThread),
+		order:    list.New(),
+		outbound: make(map[int]ConversationEntry),
+		now:      time.Now,
+	}
+}
+
+// RecordOutbound records a message sent to message.Destination, along with
+// the caller's metadata, such as the business record it relates to.
+func (s *ConversationStore) RecordOutbound(messageID int, message *Message, metadata map[string]string) ConversationEntry {
+	entry := ConversationEntry{
+		Direction: DirectionOutbound,
+		MessageID: messageID,
+		Number:    phoneNumberKey(message.Destination, s.opts.Region),
+		Content:   message.Content,
+		Metadata:  copyMetadata(metadata),
+		At:        s.now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outbound[messageID] = entry
+	s.record(entry)
+	return entry
+}
+
+// RecordInbound records a message received from message.Source, matching it
+// to the outbound message it replies to. The message named by ReplyTo is
+// preferred, falling back to the most recent message sent to the source. It
+// reports whether a match was found.
+func (s *ConversationStore) RecordInbound(message *Message) (ConversationEntry, bool) {
+	entry := ConversationEntry{
+		Direction: DirectionInbound,
+		MessageID: message.ID,
+		Number:    phoneNumberKey(message.Source, s.opts.Region),
+		Content:   message.Content,
+		At:        s.now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	original, matched := s.match(message, entry.Number, entry.At)
+	if matched {
+		entry.InReplyTo = original.MessageID
+		entry.Metadata = copyMetadata(original.Metadata)
+		if entry.Number == "" {
+			entry.Number = original.Number
+		}
+	}
+
+	s.record(entry)
+	return entry, matched
+}
+
+// match finds the outbound message an inbound message from number replies to.
+func (s *ConversationStore) match(message *Message, number string, now time.Time) (ConversationEntry, bool) {
+	cutoff := now.Add(-s.opts.MaxAge)
+	if replyTo, err := strconv.Atoi(message.ReplyTo); err == nil {
+		if original, ok := s.outbound[replyTo]; ok && !original.At.Before(cutoff) {
+			return original, true
+		}
+	}
+
+	if thread, ok := s.threads[number]; ok {
+		for i := len(thread.entries) - 1; i >= 0 && !thread.entries[i].At.Before(cutoff); i-- {
+			if thread.entries[i].Direction == DirectionOutbound {
+				return thread.entries[i], true
+			}
+		}
+	}
+
+	return ConversationEntry{}, false
+}
+
+// record appends entry to its thread, then drops whatever the store's limits
+// no longer allow it to keep.
+func (s *ConversationStore) record(entry ConversationEntry) {
+	thread, ok := s.threads[entry.Number]
+	if !ok {
+		thread = &conversationThread{number: entry.Number}
+		thread.elem = s.order.PushFront(thread)
+		s.threads[entry.Number] = thread
+	} else {
+		s.order.MoveToFront(thread.elem)
+	}
+	thread.entries = append(thread.entries, entry)
+
+	cutoff := entry.At.Add(-s.opts.MaxAge)
+	drop := 0
+	for drop < len(thread.entries) && (len(thread.entries)-drop > s.opts.MaxThreadLength || thread.entries[drop].At.Before(cutoff)) {
+		drop++
+	}
+	if drop > 0 {
+		s.forget(thread.entries[:drop])
+		thread.entries = append([]ConversationEntry(nil), thread.entries[drop:]...)
+	}
+
+	for s.order.Len() > 0 {
+		oldest := s.order.Back().Value.(*conversationThread)
+		if s.order.Len() <= s.opts.MaxThreads && !oldest.lastAt().Before(cutoff) {
+			break
+		}
+		s.order.Remove(oldest.elem)
+		delete(s.threads, oldest.number)
+		s.forget(oldest.entries)
+	}
+}
+
+// forget removes dropped entries from the index of outbound messages.
+func (s *ConversationStore) forget(entries []ConversationEntry) {
+	for _, entry := range entries {
+		if entry.Direction != DirectionOutbound {
+			continue
+		}
+		if indexed, ok := s.outbound[entry.MessageID]; ok && indexed.Number == entry.Number && indexed.At.Equal(entry.At) {
+			delete(s.outbound, entry.MessageID)
+		}
+	}
+}
+
+// lastAt returns when the last message in the thread was recorded.
+func (t *conversationThread) lastAt() time.Time {
+	if len(t.entries) == 0 {
+		return time.Time{}
+	}
+	return t.entries[len(t.entries)-1].At
+}
+
+// Thread returns every message kept in the conversation with number, oldest
+// first.
+func (s *ConversationStore) Thread(number string) []ConversationEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	thread, ok := s.threads[phoneNumberKey(number, s.opts.Region)]
+	if !ok {
+		return []ConversationEntry{}
+	}
+
+	cutoff := s.now().Add(-s.opts.MaxAge)
+	entries := make([]ConversationEntry, 0, len(thread.entries))
+	for _, entry := range thread.entries {
+		if !entry.At.Before(cutoff) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Outbound returns the recorded outbound message with the given ID.
+func (s *ConversationStore) Outbound(messageID int) (ConversationEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.outbound[messageID]
+	if !ok || entry.At.Before(s.now().Add(-s.opts.MaxAge)) {
+		return ConversationEntry{}, false
+	}
+	return entry, true
+}
+
+// ReplyFunc is called for each inbound message recorded by a conversation
+// store, with the entry recording it.
+type ReplyFunc func(ctx context.Context, message *Message, entry ConversationEntry) error
+
+// InboundMessageFunc returns an InboundMessageFunc that records each inbound
+// message before passing it on to next, if next is not nil.
+func (s *ConversationStore) InboundMessageFunc(next ReplyFunc) InboundMessageFunc {
+	return func(ctx context.Context, message *Message) error {
+		entry, _ := s.RecordInbound(message)
+		if next == nil {
+			return nil
+		}
+
+		return next(ctx, message, entry)
+	}
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+// SetConversationStore configures the client to record each message it sends
+// in store, so replies can be threaded with them. Attach metadata to the
+// recorded messages with WithConversationMetadata. A nil store disables
+// recording.
+func (c *Client) SetConversationStore(store *ConversationStore) {
+	c.conversations = store
+}
+
+// WithConversationStore records each message the client sends in store. See
+// Client.SetConversationStore.
+func WithConversationStore(store *ConversationStore) ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.conversations = store
+		return nil
+	}
+}
+
+type conversationMetadataKey struct{}
+
+// WithConversationMetadata returns a copy of ctx that records metadata with
+// each message sent using it, when the client has a conversation store.
+func WithConversationMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	return context.WithValue(ctx, conversationMetadataKey{}, metadata)
+}
+
+// recordOutbound records a sent message in the client's conversation store.
+func (c *Client) recordOutbound(ctx context.Context, messageID int, message *Message) {
+	if c.conversations == nil {
+		return
+	}
+
+	metadata, _ := ctx.Value(conversationMetadataKey{}).(map[string]string)
+	c.conversations.RecordOutbound(messageID, message, metadata)
+}
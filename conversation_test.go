@@ -0,0 +1,129 @@
+package modica
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConversationStore(t *testing.T) {
+	store := NewConversationStore(ConversationOptions{})
+	now := time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	absence := map[string]string{"student": "42", "kind": "absence"}
+	trip := map[string]string{"student": "42", "kind": "trip"}
+	store.RecordOutbound(1, &Message{Destination: "+64211111111", Content: "Aroha was absent today."}, absence)
+	store.RecordOutbound(2, &Message{Destination: "+64211111111", Content: "Is Aroha going on the trip?"}, trip)
+
+	// ReplyTo matches an older message.
+	reply, matched := store.RecordInbound(&Message{ID: 10, Source: "+64211111111", Content: "She is sick", ReplyTo: "1"})
+	if !matched || reply.InReplyTo != 1 || !reflect.DeepEqual(reply.Metadata, absence) {
+		t.Errorf("ConversationStore.RecordInbound returned %+v, %t, want a reply to message %d", reply, matched, 1)
+	}
+
+	// Without ReplyTo, the most recent message is matched, however the
+	// number is written.
+	reply, matched = store.RecordInbound(&Message{ID: 11, Source: "021 111 1111", Content: "Yes"})
+	if !matched || reply.InReplyTo != 2 || !reflect.DeepEqual(reply.Metadata, trip) {
+		t.Errorf("ConversationStore.RecordInbound returned %+v, %t, want a reply to message %d", reply, matched, 2)
+	}
+
+	// Numbers we haven't sent to don't match.
+	if _, matched := store.RecordInbound(&Message{ID: 12, Source: "+64219999999", Content: "Who is this?"}); matched {
+		t.Error("ConversationStore.RecordInbound matched a number that hasn't been sent to")
+	}
+
+	thread := store.Thread("+64211111111")
+	var got []string
+	for _, entry := range thread {
+		got = append(got, fmt.Sprintf("%s %d", entry.Direction, entry.MessageID))
+	}
+	want := []string{"outbound 1", "outbound 2", "inbound 10", "inbound 11"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ConversationStore.Thread returned %v, want %v", got, want)
+	}
+}
+
+func TestConversationStore_Limits(t *testing.T) {
+	store := NewConversationStore(ConversationOptions{MaxAge: time.Hour, MaxThreads: 2, MaxThreadLength: 2})
+	now := time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	store.RecordOutbound(1, &Message{Destination: "+64211111111", Content: "One"}, nil)
+	store.RecordOutbound(2, &Message{Destination: "+64211111111", Content: "Two"}, nil)
+	store.RecordOutbound(3, &Message{Destination: "+64211111111", Content: "Three"}, nil)
+	if thread := store.Thread("+64211111111"); len(thread) != 2 || thread[0].MessageID != 2 {
+		t.Errorf("ConversationStore.Thread returned %+v, want messages 2 and 3", thread)
+	}
+	if _, ok := store.Outbound(1); ok {
+		t.Error("ConversationStore.Outbound returned a message dropped from its thread")
+	}
+
+	// The thread with the oldest activity is dropped once there are too many.
+	store.RecordOutbound(4, &Message{Destination: "+64212222222", Content: "Four"}, nil)
+	store.RecordOutbound(5, &Message{Destination: "+64213333333", Content: "Five"}, nil)
+	if thread := store.Thread("+64211111111"); len(thread) != 0 {
+		t.Errorf("ConversationStore.Thread returned %+v for an evicted thread", thread)
+	}
+
+	// Replies aren't matched to expired messages.
+	now = now.Add(2 * time.Hour)
+	if _, matched := store.RecordInbound(&Message{ID: 6, Source: "+64213333333", Content: "Hi", ReplyTo: "5"}); matched {
+		t.Error("ConversationStore.RecordInbound matched a reply to an expired message")
+	}
+	if thread := store.Thread("+64212222222"); len(thread) != 0 {
+		t.Errorf("ConversationStore.Thread returned %+v for an expired thread", thread)
+	}
+	if len(store.threads) != 1 || len(store.outbound) != 0 {
+		t.Errorf("ConversationStore kept %d threads and %d outbound messages, want %d and %d", len(store.threads), len(store.outbound), 1, 0)
+	}
+}
+
+func TestMobileGatewayService_RecordsConversations(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	store := NewConversationStore(ConversationOptions{})
+	client.SetConversationStore(store)
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[1]`)
+	})
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"status":"success","message":null,"destination":"+64212222222","id":2},`+
+			`{"status":"failure","message":"Invalid destination (X)","destination":"X","id":null}]`)
+	})
+
+	metadata := map[string]string{"ticket": "T-1"}
+	ctx := WithConversationMetadata(context.Background(), metadata)
+	if _, err := client.MobileGateway.CreateMessageContext(ctx, &Message{Destination: "+64211111111", Content: "Hello"}); err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+	if _, err := client.MobileGateway.CreateBroadcastMessage(&BroadcastMessage{Destinations: []string{"+64212222222", "X"}, Message: Message{Content: "Hi all"}}); err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+
+	if got, ok := store.Outbound(1); !ok || got.Number != "+64211111111" || !reflect.DeepEqual(got.Metadata, metadata) {
+		t.Errorf("ConversationStore.Outbound returned %+v, want message 1 with metadata %v", got, metadata)
+	}
+	if got, ok := store.Outbound(2); !ok || got.Number != "+64212222222" || got.Content != "Hi all" {
+		t.Errorf("ConversationStore.Outbound returned %+v, want broadcast message 2", got)
+	}
+	if thread := store.Thread("X"); len(thread) != 0 {
+		t.Errorf("ConversationStore recorded a failed broadcast destination: %+v", thread)
+	}
+
+	var replied ConversationEntry
+	inbound := store.InboundMessageFunc(func(ctx context.Context, message *Message, entry ConversationEntry) error {
+		replied = entry
+		return nil
+	})
+	inbound(context.Background(), &Message{ID: 3, Source: "+64211111111", Content: "Thanks", ReplyTo: "1"})
+	if replied.InReplyTo != 1 || replied.Metadata["ticket"] != "T-1" {
+		t.Errorf("ConversationStore.InboundMessageFunc passed %+v, want a reply to message 1", replied)
+	}
+}
@@ -0,0 +1,219 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AllowlistMode determines what happens to messages sent to destinations that
+// aren't on a destination allowlist.
+type AllowlistMode int
+
+const (
+	// AllowlistBlock rejects messages to other destinations with
+	// ErrDestinationNotAllowed.
+	AllowlistBlock AllowlistMode = iota
+
+	// AllowlistRedirect sends messages to other destinations to the
+	// allowlist's catch-all number instead.
+	AllowlistRedirect
+)
+
+// RecipientAnnotation determines where the original recipient of a redirected
+// message is recorded.
+type RecipientAnnotation int
+
+const (
+	// AnnotateContent prefixes the message content with the original
+	// recipient, such as "[To +64211234567] Hello".
+	AnnotateContent RecipientAnnotation = iota
+
+	// AnnotateReference appends the original recipient to the message
+	// reference, such as "invoice-42 to:+64211234567".
+	AnnotateReference
+)
+
+// DestinationAllowlist restricts the destinations a client sends messages to,
+// so non-production environments can deliver real messages to testers
+// without reaching anyone else. Numbers and prefixes are matched against
+// destinations after they are normalised, so should be in E.164 format.
+type DestinationAllowlist struct {
+	// Numbers contains the destinations messages may be sent to.
+	Numbers []string
+
+	// Prefixes contains number prefixes, such as "+6421555", that messages
+	// may be sent to.
+	Prefixes []string
+
+	// Mode determines what happens to messages to other destinations.
+	Mode AllowlistMode
+
+	// CatchAll contains the number messages to other destinations are sent
+	// to in AllowlistRedirect mode.
+	CatchAll string
+
+	// Annotation determines where the original recipient of a redirected
+	// message is recorded.
+	Annotation RecipientAnnotation
+}
+
+// Allows reports whether messages may be sent to number.
+func (a *DestinationAllowlist) Allows(number string) bool {
+	if a.Mode == AllowlistRedirect && number == a.CatchAll {
+		return true
+	}
+
+	for _, allowed := range a.Numbers {
+		if number == allowed {
+			return true
+		}
+	}
+	for _, prefix := range a.Prefixes {
+		if strings.HasPrefix(number, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redirect returns a copy of message sent to the catch-all number, recording
+// the original recipient according to the allowlist's annotation.
+func (a *DestinationAllowlist) redirect(message *Message) *Message {
+	redirected := *message
+	redirected.Destination = a.CatchAll
+
+	switch a.Annotation {
+	case AnnotateReference:
+		if redirected.Reference == "" {
+			redirected.Reference = "to:" + message.Destination
+		} else {
+			redirected.Reference += " to:" + message.Destination
+		}
+	default:
+		redirected.Content = "[To " + message.Destination + "] " + message.Content
+	}
+
+	return &redirected
+}
+
+// validate checks the allowlist is usable.
+func (a *DestinationAllowlist) validate() error {
+	if a.Mode == AllowlistRedirect && a.CatchAll == "" {
+		return errors.New("DestinationAllowlist must have a CatchAll number to redirect to")
+	}
+
+	return nil
+}
+
+// SetDestinationAllowlist restricts the destinations the client sends messages
+// to. Messages to other destinations are rejected or redirected according to
+// the allowlist's Mode, for both single messages and broadcasts. A nil
+// allowlist removes the restriction.
+func (c *Client) SetDestinationAllowlist(allowlist *DestinationAllowlist) error {
+	if allowlist != nil {
+		if err := allowlist.validate(); err != nil {
+			return err
+		}
+	}
+
+	c.allowlist = allowlist
+	return nil
+}
+
+// WithDestinationAllowlist restricts the destinations the client sends
+// messages to. See Client.SetDestinationAllowlist.
+func WithDestinationAllowlist(allowlist *DestinationAllowlist) ClientOption {
+	return func(cfg *clientConfig) error {
+		if allowlist != nil {
+			if err := allowlist.validate(); err != nil {
+				return err
+			}
+		}
+
+		cfg.allowlist = allowlist
+		return nil
+	}
+}
+
+// checkAllowed returns ErrDestinationNotAllowed if the client blocks messages
+// to destination.
+func (m MobileGatewayService) checkAllowed(destination string) error {
+	allowlist := m.client.allowlist
+	if allowlist == nil || allowlist.Mode != AllowlistBlock || allowlist.Allows(destination) {
+		return nil
+	}
+
+	return fmt.Errorf("%w (%s)", ErrDestinationNotAllowed, destination)
+}
+
+// applyRedirect returns the message to send in place of message, redirected
+// to the client's catch-all number if its destination isn't allowed.
+func (m MobileGatewayService) applyRedirect(message *Message) *Message {
+	allowlist := m.client.allowlist
+	if message == nil || allowlist == nil || allowlist.Mode != AllowlistRedirect || allowlist.Allows(message.Destination) {
+		return message
+	}
+
+	return allowlist.redirect(message)
+}
+
+// filterAllowed splits destinations into those that may be broadcast to and
+// those that must be redirected, reporting blocked destinations as failed
+// broadcast responses.
+func (m MobileGatewayService) filterAllowed(destinations []string) (allowed []string, redirected []string, blocked []BroadcastResponse) {
+	allowlist := m.client.allowlist
+	if allowlist == nil {
+		return destinations, nil, nil
+	}
+
+	allowed = make([]string, 0, len(destinations))
+	for _, destination := range destinations {
+		switch {
+		case allowlist.Allows(destination):
+			allowed = append(allowed, destination)
+		case allowlist.Mode == AllowlistRedirect:
+			redirected = append(redirected, destination)
+		default:
+			blocked = append(blocked, BroadcastResponse{
+				Status:      MessageStatusFailure,
+				Message:     "Destination not allowed (" + destination + ")",
+				Destination: destination,
+			})
+		}
+	}
+
+	return allowed, redirected, blocked
+}
+
+// sendRedirected sends message to each destination individually, so each is
+// redirected with its own recipient recorded, returning a broadcast response
+// for each.
+func (m MobileGatewayService) sendRedirected(ctx context.Context, message Message, destinations []string) []BroadcastResponse {
+	responses := make([]BroadcastResponse, len(destinations))
+	for i, destination := range destinations {
+		single := message
+		single.Destination = destination
+
+		id, err := m.CreateMessageContext(ctx, &single)
+		if err != nil {
+			responses[i] = BroadcastResponse{
+				Status:      MessageStatusFailure,
+				Message:     err.Error(),
+				Destination: destination,
+			}
+			continue
+		}
+
+		responses[i] = BroadcastResponse{
+			Status:      MessageStatusSuccess,
+			Message:     "Redirected to " + m.client.allowlist.CatchAll,
+			Destination: destination,
+			ID:          id,
+		}
+	}
+
+	return responses
+}
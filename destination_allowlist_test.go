@@ -0,0 +1,277 @@
+package modica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDestinationAllowlist_Allows(t *testing.T) {
+	allowlist := &DestinationAllowlist{
+		Numbers:  []string{"+64211111111"},
+		Prefixes: []string{"+6422"},
+		Mode:     AllowlistRedirect,
+		CatchAll: "+64299999999",
+	}
+
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{number: "+64211111111", want: true},
+		{number: "+64211111112", want: false},
+		{number: "+64221234567", want: true},
+		{number: "+64299999999", want: true},
+	}
+	for _, test := range tests {
+		if got := allowlist.Allows(test.number); got != test.want {
+			t.Errorf("DestinationAllowlist.Allows(%q) returned %+v, want %+v", test.number, got, test.want)
+		}
+	}
+}
+
+func TestClient_SetDestinationAllowlist(t *testing.T) {
+	client := NewClient(clientID, clientSecret, nil)
+	if err := client.SetDestinationAllowlist(&DestinationAllowlist{Mode: AllowlistRedirect}); err == nil {
+		t.Error("Client.SetDestinationAllowlist should have rejected a redirect without a catch-all number")
+	}
+	if _, err := NewClientWithOptions(clientID, clientSecret, WithDestinationAllowlist(&DestinationAllowlist{Mode: AllowlistRedirect})); err == nil {
+		t.Error("NewClientWithOptions should have rejected a redirect without a catch-all number")
+	}
+}
+
+func TestMobileGatewayService_CreateMessage_AllowlistBlock(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetDestinationAllowlist(&DestinationAllowlist{Numbers: []string{"+64211111111"}})
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":"+64211111111","content":"Hello"}`+"\n")
+		fmt.Fprint(w, `[1]`)
+	})
+
+	if _, err := client.MobileGateway.CreateMessage(&Message{Destination: "+64211111111", Content: "Hello"}); err != nil {
+		t.Errorf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+	if _, err := client.MobileGateway.CreateMessage(&Message{Destination: "+64212222222", Content: "Hello"}); !errors.Is(err, ErrDestinationNotAllowed) {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrDestinationNotAllowed)
+	}
+}
+
+func TestMobileGatewayService_CreateMessage_AllowlistRedirect(t *testing.T) {
+	tests := []struct {
+		annotation RecipientAnnotation
+		want       []Message
+	}{
+		{
+			annotation: AnnotateContent,
+			want: []Message{
+				{Destination: "+64299999999", Content: "[To +64212222222] Hello", Reference: "r1"},
+				{Destination: "+64299999999", Content: "[To +64213333333] Hello", Reference: "r1"},
+			},
+		},
+		{
+			annotation: AnnotateReference,
+			want: []Message{
+				{Destination: "+64299999999", Content: "Hello", Reference: "r1 to:+64212222222"},
+				{Destination: "+64299999999", Content: "Hello", Reference: "r1 to:+64213333333"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		client, mux, _, teardown := setup()
+		// Reference deduplication must not treat messages to different
+		// recipients as repeats once they share the catch-all destination.
+		client.SetRetryPolicy(&RetryPolicy{MaxAttempts: 1})
+		client.SetDestinationAllowlist(&DestinationAllowlist{
+			Numbers:    []string{"+64211111111"},
+			Mode:       AllowlistRedirect,
+			CatchAll:   "+64299999999",
+			Annotation: test.annotation,
+		})
+
+		var got []Message
+		mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+			var message Message
+			json.NewDecoder(r.Body).Decode(&message)
+			got = append(got, message)
+			fmt.Fprintf(w, `[%d]`, len(got))
+		})
+
+		for _, destination := range []string{"+64212222222", "+64213333333"} {
+			if _, err := client.MobileGateway.CreateMessage(&Message{Destination: destination, Content: "Hello", Reference: "r1"}); err != nil {
+				t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+			}
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("MobileGateway.CreateMessage sent %+v, want %+v", got, test.want)
+		}
+		teardown()
+	}
+}
+
+func TestMobileGatewayService_CreateBroadcastMessage_Allowlist(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":["+64211111111"],"content":"Hello"}`+"\n")
+		fmt.Fprint(w, `[{"status":"success","message":null,"destination":"+64211111111","id":1}]`)
+	})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":"+64299999999","content":"[To +64212222222] Hello"}`+"\n")
+		fmt.Fprint(w, `[2]`)
+	})
+
+	payload := &BroadcastMessage{
+		Destinations: []string{"+64211111111", "+64212222222"},
+		Message:      Message{Content: "Hello"},
+	}
+
+	client.SetDestinationAllowlist(&DestinationAllowlist{Numbers: []string{"+64211111111"}, Mode: AllowlistRedirect, CatchAll: "+64299999999"})
+	got, err := client.MobileGateway.CreateBroadcastMessage(payload)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+	want := []BroadcastResponse{
+		{Status: "success", Destination: "+64211111111", ID: 1},
+		{Status: "success", Message: "Redirected to +64299999999", Destination: "+64212222222", ID: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", got, want)
+	}
+
+	client.SetDestinationAllowlist(&DestinationAllowlist{Numbers: []string{"+64211111111"}})
+	got, err = client.MobileGateway.CreateBroadcastMessage(payload)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+	want = []BroadcastResponse{
+		{Status: "success", Destination: "+64211111111", ID: 1},
+		{Status: MessageStatusFailure, Message: "Destination not allowed (+64212222222)", Destination: "+64212222222"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", got, want)
+	}
+}
+
+func TestMobileGatewayService_CreateBroadcastMessage_AllowlistRedirectFailure(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetDestinationAllowlist(&DestinationAllowlist{Numbers: []string{"+64211111111"}, Mode: AllowlistRedirect, CatchAll: "+64299999999"})
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error-desc":"Invalid JSON","error":"invalid_json"}`)
+	})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Redirected destinations were sent although the broadcast failed")
+		fmt.Fprint(w, `[1]`)
+	})
+
+	_, err := client.MobileGateway.CreateBroadcastMessage(&BroadcastMessage{
+		Destinations: []string{"+64211111111", "+64212222222"},
+		Message:      Message{Content: "Hello"},
+	})
+	if !errors.Is(err, ErrMobileGatewayInvalidJSON) {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", err, ErrMobileGatewayInvalidJSON)
+	}
+}
+
+func TestMobileGatewayService_CreateBroadcastMessageBatched_AllowlistRedirect(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetDestinationAllowlist(&DestinationAllowlist{Prefixes: []string{"+642111"}, Mode: AllowlistRedirect, CatchAll: "+64299999999"})
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		var broadcast BroadcastMessage
+		json.NewDecoder(r.Body).Decode(&broadcast)
+		if len(broadcast.Destinations) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error-desc":"Broadcast limit has been exceeded","error":"broadcast_limit"}`)
+			return
+		}
+		fmt.Fprintf(w, `[{"status":"success","message":null,"destination":%q,"id":1}]`, broadcast.Destinations[0])
+	})
+	var redirects int
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		redirects++
+		fmt.Fprintf(w, `[%d]`, 100+redirects)
+	})
+
+	payload := &BroadcastMessage{
+		Destinations: []string{"+64211111111", "+64212222222", "+64211111112", "+64213333333"},
+		Message:      Message{Content: "Hello"},
+	}
+	got, err := client.MobileGateway.CreateBroadcastMessageBatched(context.Background(), payload, BroadcastBatchOptions{Concurrency: 1})
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessageBatched returned error: %v", err)
+	}
+	if redirects != 2 {
+		t.Errorf("MobileGateway.CreateBroadcastMessageBatched sent %d redirected messages, want %d", redirects, 2)
+	}
+
+	want := []BroadcastResponse{
+		{Status: "success", Destination: "+64211111111", ID: 1},
+		{Status: "success", Destination: "+64211111112", ID: 1},
+		{Status: "success", Message: "Redirected to +64299999999", Destination: "+64212222222", ID: 101},
+		{Status: "success", Message: "Redirected to +64299999999", Destination: "+64213333333", ID: 102},
+	}
+	if !reflect.DeepEqual(got.Responses, want) {
+		t.Errorf("MobileGateway.CreateBroadcastMessageBatched returned %+v, want %+v", got.Responses, want)
+	}
+}
+
+func TestMobileGatewayService_CreateMessage_AllowlistRedirectHooks(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetDestinationAllowlist(&DestinationAllowlist{
+		Mode:     AllowlistRedirect,
+		CatchAll: "+64299999999",
+	})
+	client.MobileGateway.AddPreSendHook(SegmentBudgetHook(1, nil))
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("MobileGateway.CreateMessage sent a message over its segment budget")
+		fmt.Fprint(w, `[1]`)
+	})
+
+	// The content fits in a single segment until the recipient is prepended.
+	content := strings.Repeat("a", 150)
+	if _, err := client.MobileGateway.CreateMessage(&Message{Destination: "+64212222222", Content: content}); !errors.Is(err, ErrSegmentBudgetExceeded) {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrSegmentBudgetExceeded)
+	}
+}
+
+func TestMobileGatewayService_CreateBroadcastMessageBatched_AllowlistRedirectFailure(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetDestinationAllowlist(&DestinationAllowlist{Numbers: []string{"+64211111111"}, Mode: AllowlistRedirect, CatchAll: "+64299999999"})
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error-desc":"Invalid JSON","error":"invalid_json"}`)
+	})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Redirected destinations were sent although every batch failed")
+		fmt.Fprint(w, `[1]`)
+	})
+
+	got, err := client.MobileGateway.CreateBroadcastMessageBatched(context.Background(), &BroadcastMessage{
+		Destinations: []string{"+64211111111", "+64212222222"},
+		Message:      Message{Content: "Hello"},
+	}, BroadcastBatchOptions{})
+	if !errors.Is(err, ErrBroadcastIncomplete) {
+		t.Errorf("MobileGateway.CreateBroadcastMessageBatched returned %+v, want %+v", err, ErrBroadcastIncomplete)
+	}
+	if want := []string{"+64211111111", "+64212222222"}; !reflect.DeepEqual(got.FailedDestinations(), want) {
+		t.Errorf("BroadcastBatchResult.FailedDestinations returned %v, want %v", got.FailedDestinations(), want)
+	}
+}
@@ -22,19 +22,17 @@ func main() {
 	}
 
 	for _, status := range messageStatuses {
-		switch status.Status {
-		case modica.MessageStatusSubmitted:
-			fmt.Printf("Yass! Message %s has been %s", strconv.Itoa(status.ID), status.Status)
-		case modica.MessageStatusSent:
-			fmt.Printf("Okay, we got to wait, but at least Message %s has been %s", strconv.Itoa(status.ID), status.Status)
-		case modica.MessageStatusReceived:
-			fmt.Printf("Message %s has been %s", strconv.Itoa(status.ID), status.Status)
-		case modica.MessageStatusFrozen:
-			fmt.Printf("Oh no! Message %s is stuck in terrible disney movie, also known as %s", strconv.Itoa(status.ID), status.Status)
-		case modica.MessageStatusRejected, modica.MessageStatusFailed, modica.MessageStatusDead, modica.MessageStatusExpired:
-			fmt.Printf("Oh no! Message %s has %s", strconv.Itoa(status.ID), status.Status)
+		switch {
+		case status.Status.IsFailure():
+			fmt.Printf("Oh no! Message %s has %s\n", strconv.Itoa(status.ID), status.Status)
+		case status.Status.IsSuccess():
+			fmt.Printf("Message %s has been %s\n", strconv.Itoa(status.ID), status.Status)
+		case status.Status == modica.MessageStatusFrozen:
+			fmt.Printf("Oh no! Message %s is stuck in terrible disney movie, also known as %s\n", strconv.Itoa(status.ID), status.Status)
+		case status.Status.IsKnown():
+			fmt.Printf("Okay, we got to wait, but at least Message %s has been %s\n", strconv.Itoa(status.ID), status.Status)
 		default:
-			fmt.Printf("Well, this is awkward.. Message %s's status is unknown, but we at least got this: %s", strconv.Itoa(status.ID), status.Status)
+			fmt.Printf("Well, this is awkward.. Message %s's status is unknown, but we at least got this: %s\n", strconv.Itoa(status.ID), status.Status)
 		}
 	}
 
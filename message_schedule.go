@@ -0,0 +1,104 @@
+package modica
+
+import (
+	"errors"
+	"time"
+)
+
+// SchedulePolicy configures client-side validation of scheduled send times, so
+// invalid schedules are rejected before a request is made.
+type SchedulePolicy struct {
+	// MaxHorizon contains how far into the future the account allows a
+	// message to be scheduled. Zero allows any future time.
+	MaxHorizon time.Duration
+
+	// ClockSkew contains how far the local clock is allowed to drift from the
+	// gateway's, which is allowed for when checking whether a scheduled time
+	// is in the past or beyond the horizon.
+	ClockSkew time.Duration
+
+	// Location, if set, is the time zone scheduled times are sent in.
+	// Otherwise, scheduled times are sent in the zone they were given in.
+	Location *time.Location
+
+	// now enables overriding the clock in tests.
+	now func() time.Time
+}
+
+// SetSchedulePolicy enables validating the Scheduled time of messages before
+// they are sent. Malformed times are rejected with
+// ErrMobileGatewayInvalidTimestampFormat, times in the past with
+// ErrMobileGatewayInvalidTimestamp and times beyond the policy's horizon with
+// ErrScheduledBeyondHorizon. A nil policy disables validation.
+func (c *Client) SetSchedulePolicy(policy *SchedulePolicy) {
+	c.schedulePolicy = policy
+}
+
+// WithSchedulePolicy validates the Scheduled time of messages before they are
+// sent. See Client.SetSchedulePolicy.
+func WithSchedulePolicy(policy *SchedulePolicy) ClientOption {
+	return func(cfg *clientConfig) error {
+		if policy != nil && (policy.MaxHorizon < 0 || policy.ClockSkew < 0) {
+			return errors.New("SchedulePolicy durations must not be negative")
+		}
+
+		cfg.schedulePolicy = policy
+		return nil
+	}
+}
+
+// ScheduleAt schedules the message to be sent at t. The time is sent in
+// RFC3339 format, in t's time zone, to the nearest second.
+func (m *Message) ScheduleAt(t time.Time) {
+	m.Scheduled = formatScheduled(t)
+}
+
+// ScheduledAt returns the time the message is scheduled to be sent at. The
+// zero time is returned if the message isn't scheduled.
+func (m *Message) ScheduledAt() (time.Time, error) {
+	if m.Scheduled == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, m.Scheduled)
+	if err != nil {
+		return time.Time{}, ErrMobileGatewayInvalidTimestampFormat
+	}
+
+	return t, nil
+}
+
+func formatScheduled(t time.Time) string {
+	return t.Truncate(time.Second).Format(time.RFC3339)
+}
+
+// apply validates a scheduled time, returning it formatted to be sent.
+func (p *SchedulePolicy) apply(scheduled string) (string, error) {
+	if p == nil || scheduled == "" {
+		return scheduled, nil
+	}
+
+	message := Message{Scheduled: scheduled}
+	t, err := message.ScheduledAt()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if p.now != nil {
+		now = p.now()
+	}
+
+	if t.Before(now.Add(-p.ClockSkew)) {
+		return "", ErrMobileGatewayInvalidTimestamp
+	}
+	if p.MaxHorizon > 0 && t.After(now.Add(p.MaxHorizon+p.ClockSkew)) {
+		return "", ErrScheduledBeyondHorizon
+	}
+
+	if p.Location != nil {
+		return formatScheduled(t.In(p.Location)), nil
+	}
+
+	return scheduled, nil
+}
@@ -0,0 +1,86 @@
+package modica
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMessage_ScheduleAt(t *testing.T) {
+	nzst := time.FixedZone("NZST", 12*60*60)
+	message := &Message{}
+	message.ScheduleAt(time.Date(2017, 5, 5, 10, 0, 0, 500, nzst))
+
+	want := "2017-05-05T10:00:00+12:00"
+	if message.Scheduled != want {
+		t.Errorf("Message.ScheduleAt set %q, want %q", message.Scheduled, want)
+	}
+
+	got, err := message.ScheduledAt()
+	if err != nil {
+		t.Fatalf("Message.ScheduledAt returned error: %v", err)
+	}
+	if !got.Equal(time.Date(2017, 5, 5, 10, 0, 0, 0, nzst)) {
+		t.Errorf("Message.ScheduledAt returned %v", got)
+	}
+}
+
+func TestSchedulePolicy_Apply(t *testing.T) {
+	now := time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)
+	policy := &SchedulePolicy{
+		MaxHorizon: 24 * time.Hour,
+		ClockSkew:  time.Minute,
+		now:        func() time.Time { return now },
+	}
+
+	tests := []struct {
+		scheduled string
+		want      string
+		err       error
+	}{
+		{scheduled: "", want: ""},
+		{scheduled: "2017-05-05T11:00:00Z", want: "2017-05-05T11:00:00Z"},
+		{scheduled: "2017-05-05T09:59:30Z", want: "2017-05-05T09:59:30Z"},
+		{scheduled: "2017-05-05T09:00:00Z", err: ErrMobileGatewayInvalidTimestamp},
+		{scheduled: "2017-05-07T10:00:00Z", err: ErrScheduledBeyondHorizon},
+		{scheduled: "tomorrow", err: ErrMobileGatewayInvalidTimestampFormat},
+	}
+	for _, test := range tests {
+		got, err := policy.apply(test.scheduled)
+		if err != test.err {
+			t.Errorf("SchedulePolicy.apply(%q) returned error %+v, want %+v", test.scheduled, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("SchedulePolicy.apply(%q) returned %q, want %q", test.scheduled, got, test.want)
+		}
+	}
+
+	policy.Location = time.FixedZone("NZST", 12*60*60)
+	if got, _ := policy.apply("2017-05-05T11:00:00Z"); got != "2017-05-05T23:00:00+12:00" {
+		t.Errorf("SchedulePolicy.apply returned %q in the wrong zone", got)
+	}
+}
+
+func TestMobileGatewayService_CreateMessage_SchedulePolicy(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetSchedulePolicy(&SchedulePolicy{ClockSkew: time.Minute})
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("MobileGateway.CreateMessage should not have sent a message scheduled in the past")
+		fmt.Fprint(w, `[123]`)
+	})
+
+	payload := &Message{Destination: "+642123456789", Content: "Hello"}
+	payload.ScheduleAt(time.Now().Add(-time.Hour))
+	if _, err := client.MobileGateway.CreateMessage(payload); err != ErrMobileGatewayInvalidTimestamp {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrMobileGatewayInvalidTimestamp)
+	}
+
+	broadcast := &BroadcastMessage{Destinations: []string{"+642123456789"}, Message: *payload}
+	if _, err := client.MobileGateway.CreateBroadcastMessage(broadcast); err != ErrMobileGatewayInvalidTimestamp {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", err, ErrMobileGatewayInvalidTimestamp)
+	}
+}
@@ -0,0 +1,145 @@
+package modica
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// MessageStatus describes where a message is in its delivery lifecycle.
+//
+// Statuses that aren't known to this version of the library unmarshal
+// without error, so that new statuses added to the API don't break existing
+// clients. Use IsKnown to detect them.
+type MessageStatus string
+
+const (
+	// MessageStatusSubmitted informs the end user that the message was
+	// successfully submitted to the carrier for delivery.
+	MessageStatusSubmitted MessageStatus = "submitted"
+
+	// MessageStatusSent informs the end user that the message has been sent by
+	// the carrier transport.
+	MessageStatusSent MessageStatus = "sent"
+
+	// MessageStatusReceived informs the end user that the message has been
+	// received.
+	MessageStatusReceived MessageStatus = "received"
+
+	// MessageStatusFrozen informs the end user that the a transient error has
+	// frozen this message.
+	MessageStatusFrozen MessageStatus = "frozen"
+
+	// MessageStatusRejected informs the end user that the
+	// the carrier rejected the message.
+	MessageStatusRejected MessageStatus = "rejected"
+
+	// MessageStatusFailed informs the end user that the
+	// message delivery has failed due to carrier connectivity issue
+	MessageStatusFailed MessageStatus = "failed"
+
+	// MessageStatusDead informs the end user that the
+	// message killed by administrator
+	MessageStatusDead MessageStatus = "dead"
+
+	// MessageStatusExpired informs the end user that the
+	// carrier was unable to deliver the message in a specified
+	// amount of time. For instance when the phone was turned off.
+	MessageStatusExpired MessageStatus = "expired"
+
+	// MessageStatusSuccess is returned in a broadcast response when a message
+	// was created for the destination.
+	MessageStatusSuccess MessageStatus = "success"
+
+	// MessageStatusFailure is returned in a broadcast response when a message
+	// could not be created for the destination.
+	MessageStatusFailure MessageStatus = "failure"
+)
+
+// messageStatusTransitions contains the statuses each status can legally move
+// to.
+var messageStatusTransitions = map[MessageStatus][]MessageStatus{
+	MessageStatusSuccess: {
+		MessageStatusSubmitted, MessageStatusSent, MessageStatusReceived, MessageStatusFrozen,
+		MessageStatusRejected, MessageStatusFailed, MessageStatusDead, MessageStatusExpired,
+	},
+	MessageStatusSubmitted: {
+		MessageStatusSent, MessageStatusReceived, MessageStatusFrozen,
+		MessageStatusRejected, MessageStatusFailed, MessageStatusDead, MessageStatusExpired,
+	},
+	MessageStatusSent: {
+		MessageStatusReceived, MessageStatusFrozen,
+		MessageStatusRejected, MessageStatusFailed, MessageStatusDead, MessageStatusExpired,
+	},
+	MessageStatusFrozen: {
+		MessageStatusSubmitted, MessageStatusSent, MessageStatusReceived,
+		MessageStatusRejected, MessageStatusFailed, MessageStatusDead, MessageStatusExpired,
+	},
+}
+
+// IsKnown reports whether the status is one known to this library.
+func (s MessageStatus) IsKnown() bool {
+	switch s {
+	case MessageStatusSubmitted, MessageStatusSent, MessageStatusReceived, MessageStatusFrozen,
+		MessageStatusRejected, MessageStatusFailed, MessageStatusDead, MessageStatusExpired,
+		MessageStatusSuccess, MessageStatusFailure:
+		return true
+	}
+
+	return false
+}
+
+// IsTerminal reports whether the message has reached a final status, after
+// which its status will no longer change.
+func (s MessageStatus) IsTerminal() bool {
+	return s.IsSuccess() || s.IsFailure()
+}
+
+// IsSuccess reports whether the message was delivered to the handset.
+func (s MessageStatus) IsSuccess() bool {
+	return s == MessageStatusReceived
+}
+
+// IsFailure reports whether the message will never be delivered.
+func (s MessageStatus) IsFailure() bool {
+	switch s {
+	case MessageStatusRejected, MessageStatusFailed, MessageStatusDead, MessageStatusExpired, MessageStatusFailure:
+		return true
+	}
+
+	return false
+}
+
+// CanTransitionTo reports whether a message can legally move from this status
+// to next, for example from submitted to sent. Moving to the same status, or
+// to or from an unknown status, is not a legal transition.
+func (s MessageStatus) CanTransitionTo(next MessageStatus) bool {
+	for _, allowed := range messageStatusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns the status as sent by the API.
+func (s MessageStatus) String() string {
+	return string(s)
+}
+
+// UnmarshalJSON decodes a status, trimming surrounding space and normalising
+// its case. Unknown statuses are kept, normalised the same way, and null
+// decodes to an empty status.
+func (s *MessageStatus) UnmarshalJSON(data []byte) error {
+	var status *string
+	if err := json.Unmarshal(data, &status); err != nil {
+		return err
+	}
+
+	*s = ""
+	if status != nil {
+		*s = MessageStatus(strings.ToLower(strings.TrimSpace(*status)))
+	}
+
+	return nil
+}
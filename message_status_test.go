@@ -0,0 +1,86 @@
+package modica
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMessageStatus_Predicates(t *testing.T) {
+	tests := []struct {
+		status   MessageStatus
+		known    bool
+		terminal bool
+		success  bool
+		failure  bool
+	}{
+		{status: MessageStatusSubmitted, known: true},
+		{status: MessageStatusSent, known: true},
+		{status: MessageStatusFrozen, known: true},
+		{status: MessageStatusSuccess, known: true},
+		{status: MessageStatusReceived, known: true, terminal: true, success: true},
+		{status: MessageStatusRejected, known: true, terminal: true, failure: true},
+		{status: MessageStatusFailed, known: true, terminal: true, failure: true},
+		{status: MessageStatusDead, known: true, terminal: true, failure: true},
+		{status: MessageStatusExpired, known: true, terminal: true, failure: true},
+		{status: MessageStatusFailure, known: true, terminal: true, failure: true},
+		{status: MessageStatus("teleported")},
+	}
+	for _, test := range tests {
+		if got := test.status.IsKnown(); got != test.known {
+			t.Errorf("%q.IsKnown() returned %v, want %v", test.status, got, test.known)
+		}
+		if got := test.status.IsTerminal(); got != test.terminal {
+			t.Errorf("%q.IsTerminal() returned %v, want %v", test.status, got, test.terminal)
+		}
+		if got := test.status.IsSuccess(); got != test.success {
+			t.Errorf("%q.IsSuccess() returned %v, want %v", test.status, got, test.success)
+		}
+		if got := test.status.IsFailure(); got != test.failure {
+			t.Errorf("%q.IsFailure() returned %v, want %v", test.status, got, test.failure)
+		}
+	}
+}
+
+func TestMessageStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from MessageStatus
+		to   MessageStatus
+		want bool
+	}{
+		{from: MessageStatusSubmitted, to: MessageStatusSent, want: true},
+		{from: MessageStatusSent, to: MessageStatusReceived, want: true},
+		{from: MessageStatusSent, to: MessageStatusFrozen, want: true},
+		{from: MessageStatusFrozen, to: MessageStatusSent, want: true},
+		{from: MessageStatusSent, to: MessageStatusSubmitted, want: false},
+		{from: MessageStatusReceived, to: MessageStatusFailed, want: false},
+		{from: MessageStatusSent, to: MessageStatusSent, want: false},
+		{from: MessageStatus("teleported"), to: MessageStatusSent, want: false},
+	}
+	for _, test := range tests {
+		if got := test.from.CanTransitionTo(test.to); got != test.want {
+			t.Errorf("%q.CanTransitionTo(%q) returned %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestMessageStatus_UnmarshalJSON(t *testing.T) {
+	var got struct {
+		Known   MessageStatus `json:"known"`
+		Unknown MessageStatus `json:"unknown"`
+		Null    MessageStatus `json:"null"`
+	}
+	err := json.Unmarshal([]byte(`{"known":"Received","unknown":" Teleported ","null":null}`), &got)
+	if err != nil {
+		t.Fatalf("json.Unmarshal returned error: %v", err)
+	}
+
+	if got.Known != MessageStatusReceived {
+		t.Errorf("MessageStatus unmarshaled %q, want %q", got.Known, MessageStatusReceived)
+	}
+	if got.Unknown != "teleported" {
+		t.Errorf("MessageStatus unmarshaled %q, want %q", got.Unknown, "teleported")
+	}
+	if got.Null != "" {
+		t.Errorf("MessageStatus unmarshaled %q, want empty", got.Null)
+	}
+}
@@ -0,0 +1,309 @@
+package modica
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// Locale identifies the language a template is written in, as an ISO 639-1
+// language code.
+type Locale string
+
+const (
+	// LocaleEnglish contains templates written in English.
+	LocaleEnglish Locale = "en"
+
+	// LocaleMaori contains templates written in te reo Māori.
+	LocaleMaori Locale = "mi"
+)
+
+// TemplateRegistry holds named message templates, written using text/template,
+// with a variant for each locale they are available in. It is safe for
+// concurrent use.
+type TemplateRegistry struct {
+	fallback Locale
+
+	mu        sync.RWMutex
+	templates map[string]map[Locale]*messageTemplate
+}
+
+type messageTemplate struct {
+	tmpl     *template.Template
+	required []string
+
+	// referenced contains the variables the template refers to.
+	referenced []string
+}
+
+// NewTemplateRegistry returns an empty registry. Templates requested in a
+// locale they aren't available in are rendered in the fallback locale.
+func NewTemplateRegistry(fallback Locale) *TemplateRegistry {
+	return &TemplateRegistry{
+		fallback:  fallback,
+		templates: make(map[string]map[Locale]*messageTemplate),
+	}
+}
+
+// Register parses text as the locale's variant of the named template,
+// replacing any existing variant. Rendering fails if any of the required
+// variables are missing or empty, or if the template refers to a variable
+// that isn't provided.
+func (r *TemplateRegistry) Register(name string, locale Locale, text string, required ...string) error {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.templates[name] == nil {
+		r.templates[name] = make(map[Locale]*messageTemplate)
+	}
+	r.templates[name][locale] = &messageTemplate{
+		tmpl:       tmpl,
+		required:   required,
+		referenced: referencedVariables(tmpl.Tree),
+	}
+
+	return nil
+}
+
+// Locales returns the locales the named template is available in.
+func (r *TemplateRegistry) Locales(name string) []Locale {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var locales []Locale
+	for locale := range r.templates[name] {
+		locales = append(locales, locale)
+	}
+	sort.Slice(locales, func(i, j int) bool { return locales[i] < locales[j] })
+
+	return locales
+}
+
+// Render renders the locale's variant of the named template with data,
+// returning the rendered content and the locale it was rendered in.
+func (r *TemplateRegistry) Render(name string, locale Locale, data map[string]interface{}) (string, Locale, error) {
+	tmpl, locale, err := r.lookup(name, locale)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, key := range tmpl.required {
+		if value, ok := data[key]; !ok || value == nil || value == "" {
+			return "", "", fmt.Errorf("%w: %s", ErrTemplateMissingVariable, key)
+		}
+	}
+	for _, key := range tmpl.referenced {
+		if _, ok := data[key]; !ok {
+			return "", "", fmt.Errorf("%w: %s", ErrTemplateMissingVariable, key)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.tmpl.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+
+	return buf.String(), locale, nil
+}
+
+// referencedVariables returns the variables a template refers to, such as
+// {{.Student}} or {{$.Student}}. Fields referred to inside range and with
+// blocks are skipped, as dot refers to something else there.
+func referencedVariables(tree *parse.Tree) []string {
+	seen := make(map[string]bool)
+	var variables []string
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			variables = append(variables, key)
+		}
+	}
+
+	var walkPipe func(pipe *parse.PipeNode, root bool)
+	var walkArg func(arg parse.Node, root bool)
+	var walk func(node parse.Node, root bool)
+	walkPipe = func(pipe *parse.PipeNode, root bool) {
+		if pipe == nil {
+			return
+		}
+		for _, cmd := range pipe.Cmds {
+			for _, arg := range cmd.Args {
+				walkArg(arg, root)
+			}
+		}
+	}
+	walkArg = func(arg parse.Node, root bool) {
+		switch n := arg.(type) {
+		case *parse.FieldNode:
+			if root {
+				add(n.Ident[0])
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				add(n.Ident[1])
+			}
+		case *parse.ChainNode:
+			walkArg(n.Node, root)
+		case *parse.PipeNode:
+			walkPipe(n, root)
+		}
+	}
+	walk = func(node parse.Node, root bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, root)
+			}
+		case *parse.ActionNode:
+			walkPipe(n.Pipe, root)
+		case *parse.TemplateNode:
+			walkPipe(n.Pipe, root)
+		case *parse.IfNode:
+			walkPipe(n.Pipe, root)
+			walk(n.List, root)
+			walk(n.ElseList, root)
+		case *parse.RangeNode:
+			walkPipe(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		case *parse.WithNode:
+			walkPipe(n.Pipe, root)
+			walk(n.List, false)
+			walk(n.ElseList, root)
+		}
+	}
+
+	if tree != nil {
+		walk(tree.Root, true)
+	}
+	return variables
+}
+
+// lookup returns the locale's variant of the named template, or the fallback
+// locale's variant if there isn't one.
+func (r *TemplateRegistry) lookup(name string, locale Locale) (*messageTemplate, Locale, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	variants := r.templates[name]
+	if tmpl, ok := variants[locale]; ok {
+		return tmpl, locale, nil
+	}
+	if tmpl, ok := variants[r.fallback]; ok {
+		return tmpl, r.fallback, nil
+	}
+
+	return nil, "", fmt.Errorf("%w: %s (%s)", ErrTemplateNotFound, name, locale)
+}
+
+// TemplatePreview contains rendered template content, along with how it will
+// be encoded and billed.
+type TemplatePreview struct {
+	ContentAnalysis
+
+	// Content contains the rendered content.
+	Content string
+
+	// Locale contains the locale the template was rendered in.
+	Locale Locale
+}
+
+// String formats the preview for display, with a summary line followed by the
+// rendered content.
+func (p TemplatePreview) String() string {
+	return fmt.Sprintf("[%s] %d characters, %s, %d segment(s)\n%s",
+		p.Locale, p.Characters, p.Encoding, p.Segments, p.Content)
+}
+
+// Preview renders the named template, reporting the number of segments and the
+// encoding the content would be sent with.
+func (r *TemplateRegistry) Preview(name string, locale Locale, data map[string]interface{}) (TemplatePreview, error) {
+	content, locale, err := r.Render(name, locale, data)
+	if err != nil {
+		return TemplatePreview{}, err
+	}
+
+	return TemplatePreview{
+		ContentAnalysis: AnalyzeContent(content),
+		Content:         content,
+		Locale:          locale,
+	}, nil
+}
+
+// TemplateMessage describes a message whose content is rendered from a
+// template.
+type TemplateMessage struct {
+	// Template contains the name of the template to render.
+	Template string
+
+	// Locale contains the locale to render the template in.
+	Locale Locale
+
+	// Data contains the variables the template is rendered with.
+	Data map[string]interface{}
+
+	// Message contains the rest of the message, such as its Destination.
+	// Its Content is replaced with the rendered template.
+	Message Message
+}
+
+// Message renders a templated message into a Message ready to be sent.
+func (r *TemplateRegistry) Message(tm TemplateMessage) (*Message, error) {
+	content, _, err := r.Render(tm.Template, tm.Locale, tm.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	message := tm.Message
+	message.Content = content
+	return &message, nil
+}
+
+// SetTemplateRegistry configures the registry templated messages are rendered
+// from.
+func (c *Client) SetTemplateRegistry(registry *TemplateRegistry) {
+	c.templates = registry
+}
+
+// WithTemplateRegistry renders templated messages from registry. See
+// Client.SetTemplateRegistry.
+func WithTemplateRegistry(registry *TemplateRegistry) ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.templates = registry
+		return nil
+	}
+}
+
+// CreateTemplateMessage renders a templated message with the client's template
+// registry and sends it to a single destination.
+func (m MobileGatewayService) CreateTemplateMessage(tm TemplateMessage) (messageID int, err error) {
+	return m.CreateTemplateMessageContext(context.Background(), tm)
+}
+
+// CreateTemplateMessageContext renders a templated message with the client's
+// template registry and sends it to a single destination. The request is
+// aborted if ctx is cancelled or its deadline is exceeded.
+func (m MobileGatewayService) CreateTemplateMessageContext(ctx context.Context, tm TemplateMessage) (messageID int, err error) {
+	if m.client.templates == nil {
+		return 0, fmt.Errorf("%w: no template registry is configured", ErrTemplateNotFound)
+	}
+
+	message, err := m.client.templates.Message(tm)
+	if err != nil {
+		return 0, err
+	}
+
+	return m.CreateMessageContext(ctx, message)
+}
@@ -0,0 +1,130 @@
+package modica
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func newTestTemplateRegistry(t *testing.T) *TemplateRegistry {
+	registry := NewTemplateRegistry(LocaleEnglish)
+	if err := registry.Register("absence", LocaleEnglish, "{{.Student}} was absent from {{.Period}} today.", "Student", "Period"); err != nil {
+		t.Fatalf("TemplateRegistry.Register returned error: %v", err)
+	}
+	if err := registry.Register("absence", LocaleMaori, "I ngaro a {{.Student}} i te akoranga {{.Period}} i tēnei rā.", "Student", "Period"); err != nil {
+		t.Fatalf("TemplateRegistry.Register returned error: %v", err)
+	}
+	if err := registry.Register("reminder", LocaleEnglish, "Reminder: {{.Event}}"); err != nil {
+		t.Fatalf("TemplateRegistry.Register returned error: %v", err)
+	}
+
+	return registry
+}
+
+func TestTemplateRegistry_Render(t *testing.T) {
+	registry := newTestTemplateRegistry(t)
+	absence := map[string]interface{}{"Student": "Aroha", "Period": "P3"}
+
+	tests := []struct {
+		name       string
+		template   string
+		locale     Locale
+		data       map[string]interface{}
+		want       string
+		wantLocale Locale
+	}{
+		{name: "english", template: "absence", locale: LocaleEnglish, data: absence, want: "Aroha was absent from P3 today.", wantLocale: LocaleEnglish},
+		{name: "maori", template: "absence", locale: LocaleMaori, data: absence, want: "I ngaro a Aroha i te akoranga P3 i tēnei rā.", wantLocale: LocaleMaori},
+		{name: "fallback", template: "reminder", locale: LocaleMaori, data: map[string]interface{}{"Event": "Athletics day"}, want: "Reminder: Athletics day", wantLocale: LocaleEnglish},
+	}
+	for _, test := range tests {
+		got, locale, err := registry.Render(test.template, test.locale, test.data)
+		if err != nil {
+			t.Errorf("%s: TemplateRegistry.Render returned error: %v", test.name, err)
+			continue
+		}
+		if got != test.want || locale != test.wantLocale {
+			t.Errorf("%s: TemplateRegistry.Render returned %q (%s), want %q (%s)", test.name, got, locale, test.want, test.wantLocale)
+		}
+	}
+}
+
+func TestTemplateRegistry_Render_Errors(t *testing.T) {
+	registry := newTestTemplateRegistry(t)
+
+	if _, _, err := registry.Render("absence", LocaleEnglish, map[string]interface{}{"Student": "Aroha", "Period": ""}); !errors.Is(err, ErrTemplateMissingVariable) {
+		t.Errorf("TemplateRegistry.Render returned %+v, want %+v", err, ErrTemplateMissingVariable)
+	}
+	if _, _, err := registry.Render("reminder", LocaleEnglish, map[string]interface{}{"Evnt": "Typo"}); !errors.Is(err, ErrTemplateMissingVariable) {
+		t.Errorf("TemplateRegistry.Render returned %+v, want %+v", err, ErrTemplateMissingVariable)
+	}
+	if _, _, err := registry.Render("unknown", LocaleEnglish, nil); !errors.Is(err, ErrTemplateNotFound) {
+		t.Errorf("TemplateRegistry.Render returned %+v, want %+v", err, ErrTemplateNotFound)
+	}
+	if err := registry.Register("scoped", LocaleEnglish, "{{range .Students}}{{.Name}} {{$.Period}}{{end}}"); err != nil {
+		t.Fatalf("TemplateRegistry.Register returned error: %v", err)
+	}
+	students := []map[string]interface{}{{"Name": "Aroha"}}
+	if _, _, err := registry.Render("scoped", LocaleEnglish, map[string]interface{}{"Students": students}); !errors.Is(err, ErrTemplateMissingVariable) {
+		t.Errorf("TemplateRegistry.Render returned %+v, want %+v", err, ErrTemplateMissingVariable)
+	}
+	if got, _, err := registry.Render("scoped", LocaleEnglish, map[string]interface{}{"Students": students, "Period": "P3"}); err != nil || got != "Aroha P3" {
+		t.Errorf("TemplateRegistry.Render returned %q, %v, want %q", got, err, "Aroha P3")
+	}
+	if err := registry.Register("broken", LocaleEnglish, "{{.Unclosed"); err == nil {
+		t.Error("TemplateRegistry.Register should reject a malformed template")
+	}
+}
+
+func TestTemplateRegistry_Preview(t *testing.T) {
+	registry := newTestTemplateRegistry(t)
+	data := map[string]interface{}{"Student": "Aroha", "Period": "P3"}
+
+	english, err := registry.Preview("absence", LocaleEnglish, data)
+	if err != nil {
+		t.Fatalf("TemplateRegistry.Preview returned error: %v", err)
+	}
+	if english.Encoding != EncodingGSM7 || english.Segments != 1 {
+		t.Errorf("TemplateRegistry.Preview returned %s with %d segments, want %s with %d", english.Encoding, english.Segments, EncodingGSM7, 1)
+	}
+
+	// Macrons aren't in the GSM alphabet.
+	maori, err := registry.Preview("absence", LocaleMaori, data)
+	if err != nil {
+		t.Fatalf("TemplateRegistry.Preview returned error: %v", err)
+	}
+	if maori.Encoding != EncodingUCS2 || !reflect.DeepEqual(maori.UCS2Characters, []rune{'ē', 'ā'}) {
+		t.Errorf("TemplateRegistry.Preview returned %s forced by %q, want %s forced by %q", maori.Encoding, maori.UCS2Characters, EncodingUCS2, "ēā")
+	}
+
+	want := "[en] 31 characters, GSM-7, 1 segment(s)\nAroha was absent from P3 today."
+	if got := english.String(); got != want {
+		t.Errorf("TemplatePreview.String returned %q, want %q", got, want)
+	}
+}
+
+func TestMobileGatewayService_CreateTemplateMessage(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetTemplateRegistry(newTestTemplateRegistry(t))
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":"+64211234567","content":"Aroha was absent from P3 today.","reference":"absence-1"}`+"\n")
+		fmt.Fprint(w, `[1]`)
+	})
+
+	id, err := client.MobileGateway.CreateTemplateMessage(TemplateMessage{
+		Template: "absence",
+		Locale:   LocaleEnglish,
+		Data:     map[string]interface{}{"Student": "Aroha", "Period": "P3"},
+		Message:  Message{Destination: "+64211234567", Reference: "absence-1"},
+	})
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateTemplateMessage returned error: %v", err)
+	}
+	if id != 1 {
+		t.Errorf("MobileGateway.CreateTemplateMessage returned %d, want %d", id, 1)
+	}
+}
@@ -1,9 +1,11 @@
 package modica
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"strconv"
+	"time"
 )
 
 const (
@@ -11,60 +13,128 @@ const (
 	baseBroadcastMessagePath = baseMessagePath + "/broadcast"
 )
 
-const (
-	// MessageStatusSubmitted informs the end user that the message was
-	// successfully submitted to the carrier for delivery.
-	MessageStatusSubmitted = "submitted"
-
-	// MessageStatusSent informs the end user that the message has been sent by
-	// the carrier transport.
-	MessageStatusSent = "sent"
-
-	// MessageStatusReceived informs the end user that the message has been
-	// received.
-	MessageStatusReceived = "received"
-
-	// MessageStatusFrozen informs the end user that the a transient error has
-	// frozen this message.
-	MessageStatusFrozen = "frozen"
-
-	// MessageStatusRejected informs the end user that the
-	// the carrier rejected the message.
-	MessageStatusRejected = "rejected"
-
-	// MessageStatusFailed informs the end user that the
-	// message delivery has failed due to carrier connectivity issue
-	MessageStatusFailed = "failed"
-
-	// MessageStatusDead informs the end user that the
-	// message killed by administrator
-	MessageStatusDead = "dead"
-
-	// MessageStatusExpired informs the end user that the
-	// carrier was unable to deliver the message in a specified
-	// amount of time. For instance when the phone was turned off.
-	MessageStatusExpired = "expired"
-)
-
 // MobileGatewayService implements modica's Mobile Gateway HTTPS API v2
 type MobileGatewayService service
 
+// PreSendHook is called with each message before it is sent. Returning an
+// error stops the message from being sent, with the error returned to the
+// caller. For broadcasts, the hook is called once with the shared message
+// content and attributes.
+type PreSendHook func(ctx context.Context, message *Message) error
+
+// AddPreSendHook registers a hook to be run before each message or broadcast
+// is sent. Hooks run in the order they were added. Hooks should be added
+// before the client is used to send messages.
+func (m MobileGatewayService) AddPreSendHook(hook PreSendHook) {
+	m.client.preSendHooks = append(m.client.preSendHooks, hook)
+}
+
+// runPreSendHooks runs each pre-send hook against message, stopping at the
+// first error.
+func (m MobileGatewayService) runPreSendHooks(ctx context.Context, message *Message) error {
+	for _, hook := range m.client.preSendHooks {
+		if err := hook(ctx, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // CreateMessage sends an (outbound) message to a single destination.
 func (m MobileGatewayService) CreateMessage(newMessage *Message) (messageID int, err error) {
-	req, err := m.client.newRequest(methodPost, baseMessagePath, newMessage)
+	return m.CreateMessageContext(context.Background(), newMessage)
+}
+
+// CreateMessageContext sends an (outbound) message to a single destination.
+// The request is aborted if ctx is cancelled or its deadline is exceeded.
+//
+// If the client has a retry policy and the message has a Reference, later
+// calls repeating the reference are guarded against sending the message
+// twice: a message with the same reference, destination and content as one
+// the client has sent successfully in the last 24 hours returns the original
+// message ID without being sent again, and a repeat made while the first send
+// is still in flight waits for its outcome. This doesn't make retrying an
+// ambiguous failure safe, as a send that failed without a response isn't
+// remembered.
+//
+// Messages to a destination on the client's suppression list are not sent,
+// and ErrDestinationSuppressed is returned. If the client has a destination
+// allowlist, messages to other destinations are either rejected with
+// ErrDestinationNotAllowed or redirected to its catch-all number.
+func (m MobileGatewayService) CreateMessageContext(ctx context.Context, newMessage *Message) (messageID int, err error) {
+	if ctx == nil {
+		return 0, errNilContext
+	}
+
+	start := time.Now()
+	defer func() {
+		m.client.recordCall(OperationCreate, start, err)
+		if err == nil {
+			m.client.recordMessages(OperationCreate, 1, 0)
+		}
+	}()
+
+	newMessage, err = m.prepareMessage(ctx, newMessage)
+	if err != nil {
+		return 0, err
+	}
+
+	var refKey string
+	if m.client.retryPolicy != nil && newMessage != nil {
+		refKey = sentReferenceKey(newMessage)
+	}
+	if refKey != "" {
+		id, sent, err := m.client.sentReferences.claim(ctx, refKey)
+		if err != nil {
+			return 0, err
+		}
+		if sent {
+			if info := requestInfoFromContext(ctx); info != nil {
+				info.Deduplicated = true
+			}
+			return id, nil
+		}
+
+		defer func() {
+			if messageID != 0 {
+				m.client.sentReferences.complete(refKey, messageID)
+			} else {
+				m.client.sentReferences.abandon(refKey)
+			}
+		}()
+	}
+
+	// Redirect after the reference key is taken, so messages to different
+	// recipients aren't mistaken for repeats of each other. Hooks run on the
+	// redirected message, as it is what will be sent.
+	newMessage = m.applyRedirect(newMessage)
+	if newMessage != nil {
+		if err := m.runPreSendHooks(ctx, newMessage); err != nil {
+			return 0, err
+		}
+	}
+
+	err = waitRateLimit(ctx, m.client.messageLimiter)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := m.client.newRequest(ctx, methodPost, baseMessagePath, newMessage)
 	if err != nil {
 		return
 	}
 
 	// Parse the message ID from the response body
 	var resMessageID []int
-	_, err = m.client.do(req, &resMessageID)
+	_, err = m.client.do(ctx, req, &resMessageID)
 	if err != nil && err != io.EOF {
 		return 0, err
 	}
 
 	// If a message ID exists, return it.
 	if len(resMessageID) > 0 {
+		m.client.recordOutbound(ctx, resMessageID[0], newMessage)
 		return resMessageID[0], err
 	}
 
@@ -73,25 +143,207 @@ func (m MobileGatewayService) CreateMessage(newMessage *Message) (messageID int,
 
 // GetMessage retrieves a message
 func (m MobileGatewayService) GetMessage(messageID int) (message *Message, err error) {
+	return m.GetMessageContext(context.Background(), messageID)
+}
+
+// GetMessageContext retrieves a message. The request is aborted if ctx is
+// cancelled or its deadline is exceeded.
+func (m MobileGatewayService) GetMessageContext(ctx context.Context, messageID int) (message *Message, err error) {
+	start := time.Now()
+	defer func() { m.client.recordCall(OperationGet, start, err) }()
+
 	uri := fmt.Sprintf("%s/%s", baseMessagePath, strconv.Itoa(messageID))
-	req, err := m.client.newRequest(methodGet, uri, nil)
+	req, err := m.client.newRequest(ctx, methodGet, uri, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = m.client.do(req, &message)
+	_, err = m.client.do(ctx, req, &message)
 	return message, err
 }
 
 // CreateBroadcastMessage sends an (outbound) message to multiple destinations
 func (m MobileGatewayService) CreateBroadcastMessage(newMessage *BroadcastMessage) (broadcastResponses []BroadcastResponse, err error) {
-	req, err := m.client.newRequest(methodPost, baseBroadcastMessagePath, newMessage)
+	return m.CreateBroadcastMessageContext(context.Background(), newMessage)
+}
+
+// CreateBroadcastMessageContext sends an (outbound) message to multiple
+// destinations. The request is aborted if ctx is cancelled or its deadline is
+// exceeded.
+//
+// If the client has a default region, destinations that aren't possible phone
+// numbers aren't sent, and are instead returned as failed responses after the
+// gateway's responses. The same applies to destinations on the client's
+// suppression list, and to destinations blocked by its allowlist. Destinations
+// the allowlist redirects are sent as individual messages to its catch-all
+// number once the broadcast has been accepted, and their responses follow the
+// gateway's. If the broadcast fails, they aren't sent.
+func (m MobileGatewayService) CreateBroadcastMessageContext(ctx context.Context, newMessage *BroadcastMessage) (broadcastResponses []BroadcastResponse, err error) {
+	if ctx == nil {
+		return nil, errNilContext
+	}
+
+	start := time.Now()
+	defer func() { m.client.recordBroadcast(start, broadcastResponses, err) }()
+
+	prepared, invalid, redirected, err := m.prepareBroadcast(ctx, newMessage)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = m.client.do(req, &broadcastResponses)
-	return broadcastResponses, err
+	if prepared == nil || len(prepared.Destinations) > 0 || len(invalid)+len(redirected) == 0 {
+		broadcastResponses, err = m.postBroadcast(ctx, prepared)
+		if err != nil {
+			return broadcastResponses, err
+		}
+	}
+
+	// Redirected destinations are only sent once the broadcast has been
+	// accepted, so a failed broadcast can be retried without sending them
+	// twice.
+	if len(redirected) > 0 {
+		broadcastResponses = append(broadcastResponses, m.sendRedirected(ctx, newMessage.Message, redirected)...)
+	}
+
+	return append(broadcastResponses, invalid...), nil
+}
+
+// postBroadcast sends a prepared broadcast to the gateway.
+func (m MobileGatewayService) postBroadcast(ctx context.Context, broadcast *BroadcastMessage) (broadcastResponses []BroadcastResponse, err error) {
+	err = waitRateLimit(ctx, m.client.broadcastLimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := m.client.newRequest(ctx, methodPost, baseBroadcastMessagePath, broadcast)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.client.do(ctx, req, &broadcastResponses)
+	if err != nil {
+		return broadcastResponses, err
+	}
+
+	for _, response := range broadcastResponses {
+		if response.ID != 0 && !response.Status.IsFailure() {
+			message := broadcast.Message
+			message.Destination = response.Destination
+			m.client.recordOutbound(ctx, response.ID, &message)
+		}
+	}
+
+	return broadcastResponses, nil
+}
+
+// prepareMessage applies the client's local normalisation and validation to a
+// message before it is sent. If the message needs to be changed, a copy is
+// returned so the caller's message is left untouched. Pre-send hooks are left
+// to the caller, to be run once the message has been redirected.
+func (m MobileGatewayService) prepareMessage(ctx context.Context, message *Message) (*Message, error) {
+	if message == nil {
+		return nil, nil
+	}
+
+	prepared := *message
+	if m.client.defaultRegion != "" {
+		destination, err := NormalizePhoneNumber(prepared.Destination, m.client.defaultRegion)
+		if err != nil {
+			return nil, err
+		}
+		prepared.Destination = destination
+	}
+
+	if err := m.checkSuppressed(ctx, prepared.Destination); err != nil {
+		return nil, err
+	}
+
+	if err := m.checkAllowed(prepared.Destination); err != nil {
+		return nil, err
+	}
+
+	m.applyDefaultSender(&prepared)
+
+	scheduled, err := m.client.schedulePolicy.apply(prepared.Scheduled)
+	if err != nil {
+		return nil, err
+	}
+	prepared.Scheduled = scheduled
+
+	return &prepared, nil
+}
+
+// applyDefaultSender sets the client's default Source or Class on a message
+// that specifies neither.
+func (m MobileGatewayService) applyDefaultSender(message *Message) {
+	if message.Source != "" || message.Class != "" {
+		return
+	}
+
+	message.Source = m.client.defaultSource
+	message.Class = m.client.defaultClass
+}
+
+// prepareBroadcast applies the client's local normalisation and validation to
+// a broadcast before it is sent, returning a copy of the broadcast along with
+// responses for any destinations that were removed from it, and the
+// destinations the client's allowlist redirects.
+func (m MobileGatewayService) prepareBroadcast(ctx context.Context, broadcast *BroadcastMessage) (*BroadcastMessage, []BroadcastResponse, []string, error) {
+	if broadcast == nil {
+		return nil, nil, nil, nil
+	}
+
+	prepared := *broadcast
+	m.applyDefaultSender(&prepared.Message)
+
+	scheduled, err := m.client.schedulePolicy.apply(prepared.Scheduled)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	prepared.Scheduled = scheduled
+
+	if err := m.runPreSendHooks(ctx, &prepared.Message); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var invalid []BroadcastResponse
+	if m.client.defaultRegion != "" {
+		prepared.Destinations, invalid = m.normalizeDestinations(prepared.Destinations)
+	}
+
+	unsuppressed, suppressed, err := m.filterSuppressed(ctx, prepared.Destinations)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	invalid = append(invalid, suppressed...)
+
+	allowed, redirected, blocked := m.filterAllowed(unsuppressed)
+	prepared.Destinations = allowed
+
+	return &prepared, append(invalid, blocked...), redirected, nil
+}
+
+// normalizeDestinations returns destinations normalised into E.164 format.
+// Destinations that aren't possible numbers are removed, and reported as
+// failed broadcast responses in the same way as the gateway reports invalid
+// destinations.
+func (m MobileGatewayService) normalizeDestinations(destinations []string) ([]string, []BroadcastResponse) {
+	var invalid []BroadcastResponse
+	normalized := make([]string, 0, len(destinations))
+	for _, destination := range destinations {
+		number, err := NormalizePhoneNumber(destination, m.client.defaultRegion)
+		if err != nil {
+			invalid = append(invalid, BroadcastResponse{
+				Status:      MessageStatusFailure,
+				Message:     "Invalid destination (" + destination + ")",
+				Destination: destination,
+			})
+			continue
+		}
+		normalized = append(normalized, number)
+	}
+
+	return normalized, invalid
 }
 
 // Message provides the data model to unmarshal and marshal a single message
@@ -151,6 +403,9 @@ type Message struct {
 	 * operation.
 	 */
 
+	// Status contains the delivery status of the message.
+	Status MessageStatus `json:"status,omitempty"`
+
 	// ReplyTo contains the message ID to reply to.
 	ReplyTo string `json:"reply_to,omitempty"`
 
@@ -172,8 +427,8 @@ type BroadcastMessage struct {
 // BroadcastResponse provides the data model to unmarshal the response returned
 // when a broadcast message has been successfully created.
 type BroadcastResponse struct {
-	Status      string `json:"status"`
-	Message     string `json:"message"`
-	Destination string `json:"destination"`
-	ID          int    `json:"id"`
+	Status      MessageStatus `json:"status"`
+	Message     string        `json:"message"`
+	Destination string        `json:"destination"`
+	ID          int           `json:"id"`
 }
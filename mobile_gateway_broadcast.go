@@ -0,0 +1,234 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBroadcastBatchSize contains the default number of destinations
+	// sent in each batch of a batched broadcast.
+	DefaultBroadcastBatchSize = 1000
+
+	// DefaultBroadcastConcurrency contains the default number of batches of
+	// a batched broadcast sent at once.
+	DefaultBroadcastConcurrency = 4
+)
+
+// BroadcastBatchOptions configures how a broadcast is split into batches.
+type BroadcastBatchOptions struct {
+	// BatchSize contains the maximum number of destinations sent in each
+	// batch. Defaults to DefaultBroadcastBatchSize.
+	BatchSize int
+
+	// Concurrency contains the maximum number of batches sent at once.
+	// Defaults to DefaultBroadcastConcurrency.
+	Concurrency int
+}
+
+// BroadcastBatchFailure records a batch of destinations that couldn't be sent.
+type BroadcastBatchFailure struct {
+	// Destinations contains the destinations in the batch.
+	Destinations []string
+
+	// Err contains the reason the batch failed.
+	Err error
+
+	// Ambiguous is set if the batch may have been queued by the gateway
+	// despite the failure, such as when the connection dropped or the
+	// request was cancelled after being sent. Sending the batch again could
+	// deliver the message twice.
+	Ambiguous bool
+}
+
+// BroadcastBatchResult contains the merged outcome of a batched broadcast.
+type BroadcastBatchResult struct {
+	// Responses contains the gateway's responses for every batch that was
+	// sent, in the order the destinations were given, followed by responses
+	// for destinations that were redirected or removed before sending.
+	Responses []BroadcastResponse
+
+	// Failures contains each batch that couldn't be sent.
+	Failures []BroadcastBatchFailure
+}
+
+// FailedDestinations returns the destinations of every batch that is known
+// not to have been sent, so the broadcast can be resumed without resending to
+// destinations that were successful. Batches whose failure was ambiguous are
+// left out, as they may already have been queued; see AmbiguousDestinations.
+func (r *BroadcastBatchResult) FailedDestinations() []string {
+	var destinations []string
+	for _, failure := range r.Failures {
+		if !failure.Ambiguous {
+			destinations = append(destinations, failure.Destinations...)
+		}
+	}
+
+	return destinations
+}
+
+// AmbiguousDestinations returns the destinations of every batch that failed
+// in a way that leaves it unknown whether the gateway queued it. Check the
+// status of these destinations before resending to them.
+func (r *BroadcastBatchResult) AmbiguousDestinations() []string {
+	var destinations []string
+	for _, failure := range r.Failures {
+		if failure.Ambiguous {
+			destinations = append(destinations, failure.Destinations...)
+		}
+	}
+
+	return destinations
+}
+
+// CreateBroadcastMessageBatched sends an (outbound) message to any number of
+// destinations, splitting them into batches that fit within the gateway's
+// broadcast limit and sending the batches concurrently. If the gateway still
+// reports the broadcast limit has been exceeded, the batch is halved and
+// retried.
+//
+// Destinations are normalised and filtered once before being split, as
+// CreateBroadcastMessageContext does. Destinations redirected by the client's
+// allowlist are sent individually once every batch has succeeded; if any
+// batch fails, they are reported as a failure instead.
+//
+// The responses of successful batches are merged into the result. If any batch
+// fails, ErrBroadcastIncomplete is returned along with the result, whose
+// FailedDestinations can be passed to a later broadcast to resume it.
+func (m MobileGatewayService) CreateBroadcastMessageBatched(ctx context.Context, newMessage *BroadcastMessage, opts BroadcastBatchOptions) (*BroadcastBatchResult, error) {
+	if ctx == nil {
+		return nil, errNilContext
+	}
+	if newMessage == nil {
+		responses, err := m.CreateBroadcastMessageContext(ctx, newMessage)
+		return &BroadcastBatchResult{Responses: responses}, err
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBroadcastBatchSize
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBroadcastConcurrency
+	}
+
+	// Destinations are filtered once up front, so batches only contain
+	// destinations that are sent in the broadcast itself, and halving a
+	// batch never resends redirected destinations.
+	prepared, invalid, redirected, err := m.prepareBroadcast(ctx, newMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	var batches [][]string
+	for start := 0; start < len(prepared.Destinations); start += batchSize {
+		end := start + batchSize
+		if end > len(prepared.Destinations) {
+			end = len(prepared.Destinations)
+		}
+		batches = append(batches, prepared.Destinations[start:end])
+	}
+
+	results := make([]BroadcastBatchResult, len(batches))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Failures = []BroadcastBatchFailure{{Destinations: batch, Err: ctx.Err()}}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, batch []string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			m.sendBatch(ctx, prepared.Message, batch, &results[i])
+		}(i, batch)
+	}
+	wg.Wait()
+
+	merged := &BroadcastBatchResult{}
+	for _, result := range results {
+		merged.Responses = append(merged.Responses, result.Responses...)
+		merged.Failures = append(merged.Failures, result.Failures...)
+	}
+	if len(redirected) > 0 {
+		if len(merged.Failures) == 0 {
+			merged.Responses = append(merged.Responses, m.sendRedirected(ctx, newMessage.Message, redirected)...)
+		} else {
+			merged.Failures = append(merged.Failures, BroadcastBatchFailure{
+				Destinations: redirected,
+				Err:          fmt.Errorf("%w (redirected destinations are only sent once every batch succeeds)", ErrBroadcastIncomplete),
+			})
+		}
+	}
+	merged.Responses = append(merged.Responses, invalid...)
+	m.client.recordMessages(OperationBroadcast, 0, len(invalid))
+
+	if len(merged.Failures) > 0 {
+		return merged, ErrBroadcastIncomplete
+	}
+
+	return merged, nil
+}
+
+// sendBatch sends a single batch of a prepared broadcast, recording the outcome in
+// result. Batches rejected for exceeding the broadcast limit are halved and
+// sent again.
+func (m MobileGatewayService) sendBatch(ctx context.Context, message Message, destinations []string, result *BroadcastBatchResult) {
+	if err := ctx.Err(); err != nil {
+		result.Failures = append(result.Failures, BroadcastBatchFailure{
+			Destinations: destinations,
+			Err:          err,
+		})
+		return
+	}
+
+	start := time.Now()
+	responses, err := m.postBroadcast(ctx, &BroadcastMessage{
+		Destinations: destinations,
+		Message:      message,
+	})
+	m.client.recordBroadcast(start, responses, err)
+	if err == nil {
+		result.Responses = append(result.Responses, responses...)
+		return
+	}
+
+	if errors.Is(err, ErrMobileGatewayBroadcastLimit) && len(destinations) > 1 {
+		half := len(destinations) / 2
+		m.sendBatch(ctx, message, destinations[:half], result)
+		m.sendBatch(ctx, message, destinations[half:], result)
+		return
+	}
+
+	result.Failures = append(result.Failures, BroadcastBatchFailure{
+		Destinations: destinations,
+		Err:          err,
+		Ambiguous:    mayHaveBeenQueued(err),
+	})
+}
+
+// mayHaveBeenQueued reports whether a failed send may still have been queued
+// by the gateway. Only error responses that show the message wasn't queued,
+// such as a rejected request, send_failed or 503, rule it out.
+func mayHaveBeenQueued(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// The request may have reached the gateway before the connection
+		// failed or the request was cancelled.
+		return true
+	}
+
+	return apiErr.StatusCode >= 500 &&
+		apiErr.StatusCode != http.StatusServiceUnavailable &&
+		!errors.Is(err, ErrMobileGatewaySendFailed)
+}
@@ -0,0 +1,146 @@
+package modica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMobileGatewayService_CreateBroadcastMessageBatched(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	var mu sync.Mutex
+	var batchSizes []int
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		var broadcast BroadcastMessage
+		json.NewDecoder(r.Body).Decode(&broadcast)
+
+		mu.Lock()
+		batchSizes = append(batchSizes, len(broadcast.Destinations))
+		mu.Unlock()
+
+		// The gateway's real limit is lower than the configured batch size.
+		if len(broadcast.Destinations) > 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error-desc":"Broadcast limit has been exceeded","error":"broadcast_limit"}`)
+			return
+		}
+
+		// Fail the batch containing +64210000004.
+		var responses []BroadcastResponse
+		for _, destination := range broadcast.Destinations {
+			if destination == "+64210000004" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error-desc":"Could not queue message due to an unknown error","error":"send_failed"}`)
+				return
+			}
+			responses = append(responses, BroadcastResponse{Status: MessageStatusSuccess, Destination: destination, ID: 1})
+		}
+		json.NewEncoder(w).Encode(responses)
+	})
+
+	payload := &BroadcastMessage{
+		Destinations: []string{"+64210000001", "+64210000002", "+64210000003", "+64210000004", "+64210000005"},
+		Message:      Message{Content: "Hello"},
+	}
+	got, err := client.MobileGateway.CreateBroadcastMessageBatched(context.Background(), payload, BroadcastBatchOptions{
+		BatchSize:   4,
+		Concurrency: 2,
+	})
+	if !errors.Is(err, ErrBroadcastIncomplete) {
+		t.Errorf("MobileGateway.CreateBroadcastMessageBatched returned %+v, want %+v", err, ErrBroadcastIncomplete)
+	}
+
+	var sent []string
+	for _, res := range got.Responses {
+		sent = append(sent, res.Destination)
+	}
+	if want := []string{"+64210000001", "+64210000002", "+64210000005"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("MobileGateway.CreateBroadcastMessageBatched sent %v, want %v", sent, want)
+	}
+	if want := []string{"+64210000003", "+64210000004"}; !reflect.DeepEqual(got.FailedDestinations(), want) {
+		t.Errorf("BroadcastBatchResult.FailedDestinations returned %v, want %v", got.FailedDestinations(), want)
+	}
+	if len(got.Failures) != 1 || !errors.Is(got.Failures[0].Err, ErrMobileGatewaySendFailed) {
+		t.Errorf("BroadcastBatchResult.Failures is %+v", got.Failures)
+	}
+}
+
+func TestMobileGatewayService_CreateBroadcastMessageBatched_Cancelled(t *testing.T) {
+	client, _, _, teardown := setup()
+	defer teardown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	payload := &BroadcastMessage{
+		Destinations: []string{"+64210000001", "+64210000002", "+64210000003"},
+		Message:      Message{Content: "Hello"},
+	}
+	got, err := client.MobileGateway.CreateBroadcastMessageBatched(ctx, payload, BroadcastBatchOptions{BatchSize: 1, Concurrency: 1})
+	if !errors.Is(err, ErrBroadcastIncomplete) {
+		t.Errorf("MobileGateway.CreateBroadcastMessageBatched returned %+v, want %+v", err, ErrBroadcastIncomplete)
+	}
+	if !reflect.DeepEqual(got.FailedDestinations(), payload.Destinations) {
+		t.Errorf("BroadcastBatchResult.FailedDestinations returned %v, want %v", got.FailedDestinations(), payload.Destinations)
+	}
+}
+
+func TestMobileGatewayService_CreateBroadcastMessageBatched_Nil(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error-desc":"Invalid JSON","error":"invalid_json"}`)
+	})
+
+	got, err := client.MobileGateway.CreateBroadcastMessageBatched(context.Background(), nil, BroadcastBatchOptions{})
+	if !errors.Is(err, ErrMobileGatewayInvalidJSON) {
+		t.Errorf("MobileGateway.CreateBroadcastMessageBatched returned %+v, want %+v", err, ErrMobileGatewayInvalidJSON)
+	}
+	if got == nil {
+		t.Error("MobileGateway.CreateBroadcastMessageBatched returned a nil result")
+	}
+}
+
+func TestMobileGatewayService_CreateBroadcastMessageBatched_Ambiguous(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		var broadcast BroadcastMessage
+		json.NewDecoder(r.Body).Decode(&broadcast)
+
+		switch broadcast.Destinations[0] {
+		case "+64210000001":
+			// The gateway may have queued the batch before failing.
+			w.WriteHeader(http.StatusBadGateway)
+		case "+64210000002":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprintf(w, `[{"status":"success","message":null,"destination":%q,"id":1}]`, broadcast.Destinations[0])
+		}
+	})
+
+	payload := &BroadcastMessage{
+		Destinations: []string{"+64210000001", "+64210000002", "+64210000003"},
+		Message:      Message{Content: "Hello"},
+	}
+	got, err := client.MobileGateway.CreateBroadcastMessageBatched(context.Background(), payload, BroadcastBatchOptions{BatchSize: 1, Concurrency: 1})
+	if !errors.Is(err, ErrBroadcastIncomplete) {
+		t.Errorf("MobileGateway.CreateBroadcastMessageBatched returned %+v, want %+v", err, ErrBroadcastIncomplete)
+	}
+	if want := []string{"+64210000002"}; !reflect.DeepEqual(got.FailedDestinations(), want) {
+		t.Errorf("BroadcastBatchResult.FailedDestinations returned %v, want %v", got.FailedDestinations(), want)
+	}
+	if want := []string{"+64210000001"}; !reflect.DeepEqual(got.AmbiguousDestinations(), want) {
+		t.Errorf("BroadcastBatchResult.AmbiguousDestinations returned %v, want %v", got.AmbiguousDestinations(), want)
+	}
+}
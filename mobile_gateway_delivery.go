@@ -0,0 +1,282 @@
+package modica
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultDeliveryMinInterval contains the default shortest wait between
+	// polls of a message's status.
+	DefaultDeliveryMinInterval = 5 * time.Second
+
+	// DefaultDeliveryMaxInterval contains the default longest wait between
+	// polls of a message's status.
+	DefaultDeliveryMaxInterval = time.Minute
+
+	// DefaultDeliveryMaxInFlight contains the default maximum number of status
+	// polls made at once.
+	DefaultDeliveryMaxInFlight = 10
+)
+
+// DeliveryOptions configures how WaitForDelivery polls for message statuses.
+type DeliveryOptions struct {
+	// MinInterval contains the wait before a message is polled again after
+	// its status changes. Defaults to DefaultDeliveryMinInterval.
+	MinInterval time.Duration
+
+	// MaxInterval caps the wait between polls. The wait doubles each time a
+	// poll finds the status unchanged. Defaults to DefaultDeliveryMaxInterval.
+	MaxInterval time.Duration
+
+	// MaxInFlight contains the maximum number of polls made at once.
+	// Defaults to DefaultDeliveryMaxInFlight.
+	MaxInFlight int
+
+	// Timeout, if set, contains how long to wait for the messages to reach a
+	// terminal status, in addition to any deadline on the context.
+	Timeout time.Duration
+
+	// Progress, if set, is called each time a message's status changes or a
+	// poll fails. It is never called concurrently.
+	Progress func(DeliveryProgress)
+}
+
+// DeliveryResult contains the last known status of a tracked message.
+type DeliveryResult struct {
+	MessageID int
+	Status    MessageStatus
+
+	// Err contains the error returned by the most recent poll, if it failed.
+	Err error
+}
+
+// Done reports whether the message has reached a terminal status, or polling
+// for it failed with an error that won't go away, such as ErrNotFound or
+// ErrUnauthorized.
+func (r DeliveryResult) Done() bool {
+	return r.Status.IsTerminal() || isPermanentPollError(r.Err)
+}
+
+// isPermanentPollError reports whether a failed poll should not be retried.
+// Only an error response from the gateway that isn't temporary, such as
+// ErrNotFound, is permanent. Any other failure, such as a poll cut short by
+// its context or a response that couldn't be decoded, is retried, as polling
+// can't send anything twice and the next poll may succeed.
+func isPermanentPollError(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && !isTransientError(err)
+}
+
+// DeliveryProgress reports a change to a tracked message.
+type DeliveryProgress struct {
+	DeliveryResult
+
+	// Completed contains the number of messages that are done, having
+	// reached a terminal status or failed permanently.
+	Completed int
+
+	// Total contains the number of messages being tracked.
+	Total int
+}
+
+// BroadcastMessageIDs returns the IDs of the messages created by a broadcast,
+// skipping destinations that failed.
+func BroadcastMessageIDs(responses []BroadcastResponse) []int {
+	var messageIDs []int
+	for _, response := range responses {
+		if response.ID != 0 && !response.Status.IsFailure() {
+			messageIDs = append(messageIDs, response.ID)
+		}
+	}
+
+	return messageIDs
+}
+
+// WaitForDelivery polls the status of each message until it reaches a
+// terminal status, or until ctx is done or the timeout passes.
+//
+// Each message is polled on its own schedule, which backs off while its status
+// is unchanged or polling it fails transiently. A message whose poll fails
+// permanently, such as with ErrNotFound, is no longer polled, and its result
+// carries the error. The results are returned in the order the message IDs were
+// given. If the wait ends before every message is done, the results are
+// returned along with the context's error.
+func (m MobileGatewayService) WaitForDelivery(ctx context.Context, messageIDs []int, opts DeliveryOptions) ([]DeliveryResult, error) {
+	return waitForDelivery(ctx, m.GetMessageContext, messageIDs, opts)
+}
+
+// waitForDelivery polls the status of each message with get, as described by
+// WaitForDelivery.
+func waitForDelivery(ctx context.Context, get func(ctx context.Context, messageID int) (*Message, error), messageIDs []int, opts DeliveryOptions) ([]DeliveryResult, error) {
+	if ctx == nil {
+		return nil, errNilContext
+	}
+
+	if opts.MinInterval <= 0 {
+		opts.MinInterval = DefaultDeliveryMinInterval
+	}
+	if opts.MaxInterval < opts.MinInterval {
+		opts.MaxInterval = DefaultDeliveryMaxInterval
+		if opts.MaxInterval < opts.MinInterval {
+			opts.MaxInterval = opts.MinInterval
+		}
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = DefaultDeliveryMaxInFlight
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	now := time.Now()
+	tracked := make(map[int]*trackedMessage, len(messageIDs))
+	queue := make(pollQueue, 0, len(messageIDs))
+	for _, messageID := range messageIDs {
+		if _, ok := tracked[messageID]; ok {
+			continue
+		}
+
+		t := &trackedMessage{
+			result:   DeliveryResult{MessageID: messageID},
+			interval: opts.MinInterval,
+			next:     now,
+		}
+		tracked[messageID] = t
+		queue = append(queue, t)
+	}
+	heap.Init(&queue)
+
+	// Buffered so polls still in flight when we return don't block.
+	polls := make(chan pollResult, opts.MaxInFlight)
+	inFlight := 0
+	completed := 0
+
+	for completed < len(tracked) {
+		for inFlight < opts.MaxInFlight && queue.Len() > 0 && !queue[0].next.After(time.Now()) {
+			t := heap.Pop(&queue).(*trackedMessage)
+			inFlight++
+			go func(messageID int) {
+				message, err := get(ctx, messageID)
+				polls <- pollResult{messageID: messageID, message: message, err: err}
+			}(t.result.MessageID)
+		}
+
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if inFlight < opts.MaxInFlight && queue.Len() > 0 {
+			timer = time.NewTimer(time.Until(queue[0].next))
+			wait = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return deliveryResults(messageIDs, tracked), ctx.Err()
+
+		case <-wait:
+
+		case poll := <-polls:
+			inFlight--
+
+			t := tracked[poll.messageID]
+			changed := t.update(poll, opts)
+			if t.result.Done() {
+				completed++
+			} else {
+				t.next = time.Now().Add(t.interval)
+				heap.Push(&queue, t)
+			}
+
+			if changed && opts.Progress != nil {
+				opts.Progress(DeliveryProgress{
+					DeliveryResult: t.result,
+					Completed:      completed,
+					Total:          len(tracked),
+				})
+			}
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	return deliveryResults(messageIDs, tracked), nil
+}
+
+// deliveryResults returns the results for each message ID, in order.
+func deliveryResults(messageIDs []int, tracked map[int]*trackedMessage) []DeliveryResult {
+	results := make([]DeliveryResult, len(messageIDs))
+	for i, messageID := range messageIDs {
+		results[i] = tracked[messageID].result
+	}
+
+	return results
+}
+
+type pollResult struct {
+	messageID int
+	message   *Message
+	err       error
+}
+
+// trackedMessage contains the polling state of a message.
+type trackedMessage struct {
+	result   DeliveryResult
+	interval time.Duration
+	next     time.Time
+}
+
+// update records the outcome of a poll, adapting the interval until the next
+// poll, and reports whether the result changed.
+func (t *trackedMessage) update(poll pollResult, opts DeliveryOptions) bool {
+	if poll.err != nil {
+		t.result.Err = poll.err
+		t.backoff(opts)
+		return true
+	}
+
+	hadErr := t.result.Err != nil
+	t.result.Err = nil
+	if poll.message == nil || poll.message.Status == t.result.Status {
+		t.backoff(opts)
+		return hadErr
+	}
+
+	t.result.Status = poll.message.Status
+	t.interval = opts.MinInterval
+	return true
+}
+
+func (t *trackedMessage) backoff(opts DeliveryOptions) {
+	t.interval *= 2
+	if t.interval > opts.MaxInterval {
+		t.interval = opts.MaxInterval
+	}
+}
+
+// pollQueue orders tracked messages by when they are next due to be polled.
+type pollQueue []*trackedMessage
+
+func (q pollQueue) Len() int           { return len(q) }
+func (q pollQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+func (q pollQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *pollQueue) Push(x interface{}) {
+	*q = append(*q, x.(*trackedMessage))
+}
+
+func (q *pollQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,242 @@
+package modica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMobileGatewayService_WaitForDelivery(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	// Each message moves through these statuses, one per poll.
+	lifecycles := map[string][]MessageStatus{
+		"1": {MessageStatusSubmitted, MessageStatusSent, MessageStatusReceived},
+		"2": {MessageStatusSubmitted, MessageStatusRejected},
+	}
+	var mu sync.Mutex
+	polls := map[string]int{}
+	mux.HandleFunc("/messages/", func(w http.ResponseWriter, r *http.Request) {
+		testMethod(t, r, "GET")
+		id := strings.TrimPrefix(r.URL.Path, "/messages/")
+
+		mu.Lock()
+		lifecycle := lifecycles[id]
+		status := lifecycle[polls[id]]
+		if polls[id] < len(lifecycle)-1 {
+			polls[id]++
+		}
+		mu.Unlock()
+
+		fmt.Fprintf(w, `{"id":%s,"status":"%s"}`, id, status)
+	})
+
+	var progress []DeliveryProgress
+	got, err := client.MobileGateway.WaitForDelivery(context.Background(), []int{1, 2}, DeliveryOptions{
+		MinInterval: time.Millisecond,
+		MaxInterval: 2 * time.Millisecond,
+		MaxInFlight: 1,
+		Timeout:     time.Second,
+		Progress: func(p DeliveryProgress) {
+			progress = append(progress, p)
+		},
+	})
+	if err != nil {
+		t.Fatalf("MobileGateway.WaitForDelivery returned error: %v", err)
+	}
+
+	want := []DeliveryResult{
+		{MessageID: 1, Status: MessageStatusReceived},
+		{MessageID: 2, Status: MessageStatusRejected},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MobileGateway.WaitForDelivery returned %+v, want %+v", got, want)
+	}
+
+	if len(progress) != 5 {
+		t.Fatalf("Progress called %d times, want %d", len(progress), 5)
+	}
+	last := progress[len(progress)-1]
+	if last.Completed != 2 || last.Total != 2 {
+		t.Errorf("Progress reported %d/%d, want 2/2", last.Completed, last.Total)
+	}
+}
+
+func TestMobileGatewayService_WaitForDelivery_Timeout(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/messages/1", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":1,"status":"submitted"}`)
+	})
+
+	got, err := client.MobileGateway.WaitForDelivery(context.Background(), []int{1}, DeliveryOptions{
+		MinInterval: time.Millisecond,
+		MaxInterval: time.Millisecond,
+		Timeout:     20 * time.Millisecond,
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("MobileGateway.WaitForDelivery returned %+v, want %+v", err, context.DeadlineExceeded)
+	}
+
+	want := []DeliveryResult{{MessageID: 1, Status: MessageStatusSubmitted}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MobileGateway.WaitForDelivery returned %+v, want %+v", got, want)
+	}
+}
+
+func TestMobileGatewayService_WaitForDelivery_MaxInFlight(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mux.HandleFunc("/messages/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+		fmt.Fprintf(w, `{"id":%s,"status":"received"}`, strings.TrimPrefix(r.URL.Path, "/messages/"))
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	})
+
+	messageIDs := make([]int, 50)
+	for i := range messageIDs {
+		messageIDs[i] = i + 1
+	}
+	got, err := client.MobileGateway.WaitForDelivery(context.Background(), messageIDs, DeliveryOptions{MaxInFlight: 3})
+	if err != nil {
+		t.Fatalf("MobileGateway.WaitForDelivery returned error: %v", err)
+	}
+	for _, result := range got {
+		if !result.Done() {
+			t.Errorf("MobileGateway.WaitForDelivery returned unfinished result %+v", result)
+		}
+	}
+	if maxInFlight > 3 {
+		t.Errorf("MobileGateway.WaitForDelivery made %d polls at once, want at most %d", maxInFlight, 3)
+	}
+}
+
+func TestBroadcastMessageIDs(t *testing.T) {
+	responses := []BroadcastResponse{
+		{Status: MessageStatusSuccess, Destination: "+64211111111", ID: 1},
+		{Status: MessageStatusFailure, Destination: "X", Message: "Invalid destination (X)"},
+		{Status: MessageStatusSuccess, Destination: "+64212222222", ID: 2},
+	}
+
+	got := BroadcastMessageIDs(responses)
+	if want := []int{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BroadcastMessageIDs returned %v, want %v", got, want)
+	}
+}
+
+func TestMobileGatewayService_WaitForDelivery_PermanentError(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	var mu sync.Mutex
+	polls := 0
+	mux.HandleFunc("/messages/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/messages/1" {
+			fmt.Fprint(w, `{"id":1,"status":"received"}`)
+			return
+		}
+
+		mu.Lock()
+		polls++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	got, err := client.MobileGateway.WaitForDelivery(context.Background(), []int{1, 2}, DeliveryOptions{
+		MinInterval: time.Millisecond,
+		MaxInterval: time.Millisecond,
+		Timeout:     time.Second,
+	})
+	if err != nil {
+		t.Fatalf("MobileGateway.WaitForDelivery returned error: %v", err)
+	}
+
+	if len(got) != 2 || !got[1].Done() || !errors.Is(got[1].Err, ErrNotFound) {
+		t.Errorf("MobileGateway.WaitForDelivery returned %+v, want message 2 done with %v", got, ErrNotFound)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if polls != 1 {
+		t.Errorf("MobileGateway.WaitForDelivery polled message 2 %d times, want %d", polls, 1)
+	}
+}
+
+func TestMobileGatewayService_WaitForDelivery_UndecodableResponse(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	var mu sync.Mutex
+	polls := 0
+	mux.HandleFunc("/messages/1", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls++
+		first := polls == 1
+		mu.Unlock()
+
+		if first {
+			fmt.Fprint(w, `{"id":1,"status":`)
+			return
+		}
+		fmt.Fprint(w, `{"id":1,"status":"received"}`)
+	})
+
+	got, err := client.MobileGateway.WaitForDelivery(context.Background(), []int{1}, DeliveryOptions{
+		MinInterval: time.Millisecond,
+		MaxInterval: time.Millisecond,
+		Timeout:     time.Second,
+	})
+	if err != nil {
+		t.Fatalf("MobileGateway.WaitForDelivery returned error: %v", err)
+	}
+
+	want := []DeliveryResult{{MessageID: 1, Status: MessageStatusReceived}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MobileGateway.WaitForDelivery returned %+v, want %+v", got, want)
+	}
+}
+
+func TestDeliveryResult_Done(t *testing.T) {
+	tests := []struct {
+		name   string
+		result DeliveryResult
+		want   bool
+	}{
+		{"pending", DeliveryResult{Status: MessageStatusSubmitted}, false},
+		{"terminal", DeliveryResult{Status: MessageStatusReceived}, true},
+		{"not found", DeliveryResult{Err: &APIError{StatusCode: http.StatusNotFound, err: ErrNotFound}}, true},
+		{"unauthorized", DeliveryResult{Err: &APIError{StatusCode: http.StatusUnauthorized, err: ErrUnauthorized}}, true},
+		{"server error", DeliveryResult{Err: &APIError{StatusCode: http.StatusBadGateway}}, false},
+		{"cancelled", DeliveryResult{Err: context.Canceled}, false},
+		{"undecodable response", DeliveryResult{Err: &json.SyntaxError{}}, false},
+		{"truncated response", DeliveryResult{Err: io.ErrUnexpectedEOF}, false},
+	}
+
+	for _, test := range tests {
+		if got := test.result.Done(); got != test.want {
+			t.Errorf("DeliveryResult.Done(%s) returned %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -42,6 +42,30 @@ var (
 	ErrMobileGatewayMessageIDNotFound = errors.New("message id not found")
 )
 
+// Mobile Gateway client errors, returned by the client itself rather than by
+// the API
+var (
+	// ErrBroadcastIncomplete is returned by a batched broadcast when one or
+	// more of its batches couldn't be sent.
+	ErrBroadcastIncomplete = errors.New("broadcast incomplete, one or more batches failed to send")
+
+	// ErrScheduledBeyondHorizon is returned when a message is scheduled
+	// further into the future than the client's schedule policy allows.
+	ErrScheduledBeyondHorizon = errors.New("scheduled timestamp is beyond the maximum scheduling horizon")
+
+	// ErrDestinationSuppressed is returned when a message is sent to a
+	// destination on the client's suppression list.
+	ErrDestinationSuppressed = errors.New("destination has opted out of receiving messages")
+
+	// ErrDestinationNotAllowed is returned when a message is sent to a
+	// destination that isn't on the client's destination allowlist.
+	ErrDestinationNotAllowed = errors.New("destination is not on the allowlist")
+
+	// ErrSegmentBudgetExceeded is returned by SegmentBudgetHook when a
+	// message's content would be sent as more SMS segments than allowed.
+	ErrSegmentBudgetExceeded = errors.New("message content exceeds the segment budget")
+)
+
 var mobileGatewayErrorMap = map[string]error{
 	errCodeSendFailed:     ErrMobileGatewaySendFailed,
 	errCodeInvalidJson:    ErrMobileGatewayInvalidJSON,
@@ -0,0 +1,140 @@
+package modica
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// maxCallbackBodyBytes limits the size of a callback payload Modica can
+	// send us, so a misbehaving client can't exhaust memory.
+	maxCallbackBodyBytes = 1 << 20
+)
+
+// InboundMessageFunc is called for each mobile originated (MO) message
+// received from Modica. Returning an error causes the handler to respond with
+// a 500, which signals Modica to retry delivery of the message later.
+type InboundMessageFunc func(ctx context.Context, message *Message) error
+
+// InboundMessageHandler implements an http.Handler that accepts Modica's
+// mobile originated (inbound) message callbacks, decoding each callback into
+// a Message before handing it to the configured InboundMessageFunc.
+type InboundMessageHandler struct {
+	callback InboundMessageFunc
+}
+
+// NewInboundMessageHandler returns an http.Handler that invokes callback for
+// each inbound message posted to it by Modica.
+func NewInboundMessageHandler(callback InboundMessageFunc) *InboundMessageHandler {
+	return &InboundMessageHandler{
+		callback: callback,
+	}
+}
+
+// inboundMessage provides the data model to unmarshal a mobile originated
+// message callback.
+type inboundMessage struct {
+	ID          int    `json:"id"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Content     string `json:"content"`
+	Operator    string `json:"operator"`
+
+	// ReplyTo is sent as either a JSON string or number, depending on the
+	// gateway version, so is decoded by hand.
+	ReplyTo json.RawMessage `json:"reply_to"`
+}
+
+// ServeHTTP decodes an inbound message callback and passes it on to the
+// handler's callback.
+func (h *InboundMessageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != methodPost {
+		w.Header().Set("Allow", methodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var payload inboundMessage
+	body := http.MaxBytesReader(w, r.Body, maxCallbackBodyBytes)
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		http.Error(w, "invalid json data in the request body", http.StatusBadRequest)
+		return
+	}
+
+	replyTo, err := decodeReplyTo(payload.ReplyTo)
+	if err != nil {
+		http.Error(w, "invalid reply_to attribute value", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Source == "" || payload.Destination == "" {
+		http.Error(w, "missing a required attribute", http.StatusUnprocessableEntity)
+		return
+	}
+
+	message := &Message{
+		ID:          payload.ID,
+		Source:      payload.Source,
+		Destination: payload.Destination,
+		Content:     payload.Content,
+		ReplyTo:     replyTo,
+		Operator:    payload.Operator,
+	}
+	if h.callback != nil {
+		if err := h.callback(r.Context(), message); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// isJSONContentType returns true if the content type is absent or specifies
+// JSON. Modica doesn't always set a content type on its callbacks.
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || mediaType == mediaTypeV1
+}
+
+// decodeReplyTo converts a raw reply_to value, which may be a JSON string,
+// number or null, into its string form.
+func decodeReplyTo(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", nil
+	}
+
+	if raw[0] == '"' {
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", err
+	}
+	if _, err := strconv.ParseInt(n.String(), 10, 64); err != nil {
+		return "", err
+	}
+
+	return n.String(), nil
+}
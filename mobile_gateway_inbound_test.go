@@ -0,0 +1,127 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInboundMessageHandler(t *testing.T) {
+	var got *Message
+	handler := NewInboundMessageHandler(func(ctx context.Context, message *Message) error {
+		got = message
+		return nil
+	})
+
+	body := `{"id":456,"source":"+642123456789","destination":"2345","content":"Yes, I'll be there","operator":"2degrees","reply_to":123}`
+	req := httptest.NewRequest(methodPost, "/modica/mo", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("InboundMessageHandler returned status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	want := &Message{
+		ID:          456,
+		Source:      "+642123456789",
+		Destination: "2345",
+		Content:     "Yes, I'll be there",
+		ReplyTo:     "123",
+		Operator:    "2degrees",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("InboundMessageHandler decoded %+v, want %+v", got, want)
+	}
+}
+
+func TestInboundMessageHandler_ReplyToString(t *testing.T) {
+	var got *Message
+	handler := NewInboundMessageHandler(func(ctx context.Context, message *Message) error {
+		got = message
+		return nil
+	})
+
+	body := `{"source":"+642123456789","destination":"2345","content":"Yes","reply_to":"123"}`
+	req := httptest.NewRequest(methodPost, "/modica/mo", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("InboundMessageHandler returned status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got.ReplyTo != "123" {
+		t.Errorf("InboundMessageHandler decoded ReplyTo %q, want %q", got.ReplyTo, "123")
+	}
+}
+
+func TestInboundMessageHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		callbackErr error
+		want        int
+	}{
+		{
+			name:   "wrong method",
+			method: methodGet,
+			want:   http.StatusMethodNotAllowed,
+		},
+		{
+			name:        "wrong content type",
+			method:      methodPost,
+			contentType: "text/plain",
+			body:        `{}`,
+			want:        http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "malformed json",
+			method: methodPost,
+			body:   `{"source":`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid reply_to",
+			method: methodPost,
+			body:   `{"source":"+642123456789","destination":"2345","reply_to":{}}`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "missing source",
+			method: methodPost,
+			body:   `{"destination":"2345","content":"Yes"}`,
+			want:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "callback failed",
+			method:      methodPost,
+			body:        `{"source":"+642123456789","destination":"2345","content":"Yes"}`,
+			callbackErr: errors.New("database unavailable"),
+			want:        http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		handler := NewInboundMessageHandler(func(ctx context.Context, message *Message) error {
+			return test.callbackErr
+		})
+
+		req := httptest.NewRequest(test.method, "/modica/mo", strings.NewReader(test.body))
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.want {
+			t.Errorf("%s: InboundMessageHandler returned status %d, want %d", test.name, rec.Code, test.want)
+		}
+	}
+}
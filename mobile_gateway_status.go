@@ -0,0 +1,126 @@
+package modica
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StatusEvent describes a delivery status (DLR) change for a message as
+// reported by Modica.
+type StatusEvent struct {
+	// MessageID contains the ID of the message the status applies to.
+	MessageID int
+
+	// Status contains the new status of the message.
+	Status MessageStatus
+
+	// Timestamp contains when the status change occurred. If Modica doesn't
+	// provide a timestamp, the time the callback was received is used.
+	Timestamp time.Time
+
+	// Reference contains the reference the message was created with.
+	Reference string
+}
+
+// StatusListener is called for each delivery status event received from
+// Modica. Returning an error causes the handler to respond with a 500, which
+// signals Modica to retry delivery of the status callback later.
+type StatusListener func(ctx context.Context, event StatusEvent) error
+
+// StatusHandler implements an http.Handler that accepts Modica's delivery
+// status callbacks, decoding each callback into a StatusEvent before handing
+// it to every registered StatusListener.
+type StatusHandler struct {
+	mu        sync.RWMutex
+	listeners []StatusListener
+
+	// now enables overriding the clock in tests.
+	now func() time.Time
+}
+
+// NewStatusHandler returns an http.Handler that passes delivery status events
+// posted to it by Modica on to the provided listeners.
+func NewStatusHandler(listeners ...StatusListener) *StatusHandler {
+	return &StatusHandler{
+		listeners: listeners,
+		now:       time.Now,
+	}
+}
+
+// AddListener registers a listener to be called for each status event. It is
+// safe to call while the handler is serving requests.
+func (h *StatusHandler) AddListener(listener StatusListener) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.listeners = append(h.listeners, listener)
+}
+
+// statusCallback provides the data model to unmarshal a delivery status
+// callback.
+type statusCallback struct {
+	MessageID int           `json:"message_id"`
+	Status    MessageStatus `json:"status"`
+	Timestamp string        `json:"timestamp"`
+	Reference string        `json:"reference"`
+}
+
+// ServeHTTP decodes a delivery status callback and passes the resulting event
+// on to the handler's listeners.
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != methodPost {
+		w.Header().Set("Allow", methodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var payload statusCallback
+	body := http.MaxBytesReader(w, r.Body, maxCallbackBodyBytes)
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		http.Error(w, "invalid json data in the request body", http.StatusBadRequest)
+		return
+	}
+
+	if payload.MessageID == 0 || payload.Status == "" {
+		http.Error(w, "missing a required attribute", http.StatusUnprocessableEntity)
+		return
+	}
+
+	timestamp := h.now()
+	if payload.Timestamp != "" {
+		t, err := time.Parse(time.RFC3339, payload.Timestamp)
+		if err != nil {
+			http.Error(w, "invalid timestamp (must be rfc3339)", http.StatusBadRequest)
+			return
+		}
+		timestamp = t
+	}
+
+	event := StatusEvent{
+		MessageID: payload.MessageID,
+		Status:    payload.Status,
+		Timestamp: timestamp,
+		Reference: payload.Reference,
+	}
+
+	h.mu.RLock()
+	listeners := h.listeners
+	h.mu.RUnlock()
+
+	for _, listener := range listeners {
+		if err := listener(r.Context(), event); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,130 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusHandler(t *testing.T) {
+	var got []StatusEvent
+	handler := NewStatusHandler(func(ctx context.Context, event StatusEvent) error {
+		got = append(got, event)
+		return nil
+	})
+	handler.AddListener(func(ctx context.Context, event StatusEvent) error {
+		got = append(got, event)
+		return nil
+	})
+
+	body := `{"message_id":123,"status":"received","timestamp":"2017-05-05T10:00:00+12:00","reference":"alt-reference"}`
+	req := httptest.NewRequest(methodPost, "/modica/dlr", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("StatusHandler returned status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	timestamp, _ := time.Parse(time.RFC3339, "2017-05-05T10:00:00+12:00")
+	event := StatusEvent{
+		MessageID: 123,
+		Status:    MessageStatusReceived,
+		Timestamp: timestamp,
+		Reference: "alt-reference",
+	}
+	want := []StatusEvent{event, event}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("StatusHandler emitted %+v, want %+v", got, want)
+	}
+}
+
+func TestStatusHandler_DefaultTimestamp(t *testing.T) {
+	now := time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)
+
+	var got StatusEvent
+	handler := NewStatusHandler(func(ctx context.Context, event StatusEvent) error {
+		got = event
+		return nil
+	})
+	handler.now = func() time.Time { return now }
+
+	body := `{"message_id":123,"status":"sent"}`
+	req := httptest.NewRequest(methodPost, "/modica/dlr", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("StatusHandler returned status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !got.Timestamp.Equal(now) {
+		t.Errorf("StatusHandler set timestamp %v, want %v", got.Timestamp, now)
+	}
+}
+
+func TestStatusHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		listenerErr error
+		want        int
+	}{
+		{
+			name:   "wrong method",
+			method: methodGet,
+			want:   http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "malformed json",
+			method: methodPost,
+			body:   `[`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid timestamp",
+			method: methodPost,
+			body:   `{"message_id":123,"status":"sent","timestamp":"yesterday"}`,
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "missing message id",
+			method: methodPost,
+			body:   `{"status":"sent"}`,
+			want:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "missing status",
+			method: methodPost,
+			body:   `{"message_id":123}`,
+			want:   http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "listener failed",
+			method:      methodPost,
+			body:        `{"message_id":123,"status":"sent"}`,
+			listenerErr: errors.New("database unavailable"),
+			want:        http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		handler := NewStatusHandler(func(ctx context.Context, event StatusEvent) error {
+			return test.listenerErr
+		})
+
+		req := httptest.NewRequest(test.method, "/modica/dlr", strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.want {
+			t.Errorf("%s: StatusHandler returned status %d, want %d", test.name, rec.Code, test.want)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package modica
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMobileGatewayService_CreateMessage_ErrMobileGatewaySendFailed(t *testing.T) {
@@ -36,7 +39,7 @@ func TestMobileGatewayService_CreateMessage_ErrMobileGatewaySendFailed(t *testin
 	}
 
 	want := ErrMobileGatewaySendFailed
-	if got != want {
+	if !errors.Is(got, want) {
 		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", got, want)
 	}
 }
@@ -63,7 +66,7 @@ func TestMobileGatewayService_CreateMessage_ErrMobileGatewayInvalidJSON(t *testi
 	}
 
 	want := ErrMobileGatewayInvalidJSON
-	if got != want {
+	if !errors.Is(got, want) {
 		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", got, want)
 	}
 }
@@ -97,7 +100,7 @@ func TestMobileGatewayService_CreateMessage_ErrMobileGatewayMissingAttribute(t *
 	}
 
 	want := ErrMobileGatewayMissingAttribute
-	if got != want {
+	if !errors.Is(got, want) {
 		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", got, want)
 	}
 }
@@ -131,7 +134,7 @@ func TestMobileGatewayService_CreateMessage_ErrMobileGatewayInvalidAttribute(t *
 	}
 
 	want := ErrMobileGatewayInvalidAttribute
-	if got != want {
+	if !errors.Is(got, want) {
 		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", got, want)
 	}
 }
@@ -165,7 +168,7 @@ func TestMobileGatewayService_CreateMessage_ErrMobileGatewayInvalidTimestampForm
 	}
 
 	want := ErrMobileGatewayInvalidTimestampFormat
-	if got != want {
+	if !errors.Is(got, want) {
 		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", got, want)
 	}
 }
@@ -199,7 +202,7 @@ func TestMobileGatewayService_CreateMessage_ErrMobileGatewayInvalidTimestamp(t *
 	}
 
 	want := ErrMobileGatewayInvalidTimestamp
-	if got != want {
+	if !errors.Is(got, want) {
 		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", got, want)
 	}
 }
@@ -213,7 +216,7 @@ func TestMobileGatewayService_CreateMessage_ErrMobileGatewayMessageIDNotFound_Em
 		testHeader(t, r, "Authorization", expectedAuthHeader)
 		testHeader(t, r, "Accept", mediaTypeV1)
 
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, `[]`)
 	})
 
@@ -247,7 +250,7 @@ func TestMobileGatewayService_CreateMessage_ErrMobileGatewayMessageIDNotFound_Em
 		testHeader(t, r, "Authorization", expectedAuthHeader)
 		testHeader(t, r, "Accept", mediaTypeV1)
 
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, ``)
 	})
 
@@ -308,6 +311,49 @@ func TestMobileGatewayService_CreateMessage(t *testing.T) {
 	}
 }
 
+func TestMobileGatewayService_CreateMessageContext_Cancelled(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("MobileGateway.CreateMessageContext should not have sent a request with a cancelled context")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	payload := &Message{
+		Destination: "+642123456789",
+		Content:     "Hi, this is a test message to ensure you are texting correctly",
+	}
+	_, got := client.MobileGateway.CreateMessageContext(ctx, payload)
+	if got != context.Canceled {
+		t.Errorf("MobileGateway.CreateMessageContext returned %+v, want %+v", got, context.Canceled)
+	}
+}
+
+func TestMobileGatewayService_GetMessageContext_DeadlineExceeded(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	done := make(chan struct{})
+	defer close(done)
+	mux.HandleFunc("/messages/123", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, got := client.MobileGateway.GetMessageContext(ctx, 123)
+	if got != context.DeadlineExceeded {
+		t.Errorf("MobileGateway.GetMessageContext returned %+v, want %+v", got, context.DeadlineExceeded)
+	}
+}
+
 func TestMobileGatewayService_GetMessage(t *testing.T) {
 	client, mux, _, teardown := setup()
 	defer teardown()
@@ -356,7 +402,7 @@ func TestMobileGatewayService_GetMessage_NotFound(t *testing.T) {
 		t.Error("MobileGateway.GetMessage didn't return an error on not found")
 	}
 
-	if err != ErrNotFound {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("MobileGateway.GetMessage returned the wrong error: got: %+v, want %+v", err, ErrNotFound)
 	}
 }
@@ -393,7 +439,7 @@ func TestMobileGatewayService_CreateBroadcastMessage_ErrMobileGatewayBroadcastLi
 	}
 
 	want := ErrMobileGatewayBroadcastLimit
-	if got != want {
+	if !errors.Is(got, want) {
 		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", got, want)
 	}
 }
@@ -2,13 +2,16 @@ package modica
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 const (
@@ -25,6 +28,12 @@ const (
 	mediaTypeV1 = "application/vnd.modica.gateway.v1+json"
 )
 
+const (
+	// headerRequestID contains the response header the gateway identifies
+	// requests with.
+	headerRequestID = "X-Request-Id"
+)
+
 // Client enables talking to Modica's API
 type Client struct {
 	client *http.Client // HTTP client used to communicate with the API.
@@ -36,9 +45,61 @@ type Client struct {
 	// User agent used when communicating with the Modica API.
 	userAgent string
 
-	// Authentication Details
-	clientID     string
-	clientSecret string
+	// Provider of the credentials used to authenticate requests.
+	credentials CredentialsProvider
+
+	// Retry policy applied to failed requests. A nil policy disables
+	// retries.
+	retryPolicy *RetryPolicy
+
+	// Message IDs assigned to previously sent references, used to avoid
+	// double sending a message when a later call repeats its reference.
+	sentReferences *referenceCache
+
+	// Region used to normalise message destinations into E.164 format before
+	// sending. If empty, destinations are sent as provided.
+	defaultRegion Region
+
+	// Policy used to validate scheduled send times before sending. A nil
+	// policy leaves validation to the gateway.
+	schedulePolicy *SchedulePolicy
+
+	// Source or Class used for messages that specify neither.
+	defaultSource string
+	defaultClass  string
+
+	// List of destinations that have opted out of receiving messages.
+	suppressions *SuppressionList
+
+	// Answers requests in place of the API when the client is in dry-run
+	// mode.
+	dryRun *dryRunTransport
+
+	// Destinations messages may be sent to, if restricted.
+	allowlist *DestinationAllowlist
+
+	// Store sent messages are recorded in, to thread replies with them.
+	conversations *ConversationStore
+
+	// Registry templated messages are rendered from.
+	templates *TemplateRegistry
+
+	// Hooks run against each message before it is sent.
+	preSendHooks []PreSendHook
+
+	// Rate limiters shared by all message and broadcast sends. A nil limiter
+	// places no limit on sends.
+	messageLimiter   *tokenBucket
+	broadcastLimiter *tokenBucket
+
+	// Logger that requests and responses are logged to, with sensitive details
+	// redacted. A nil logger disables logging.
+	logger       Logger
+	logRedaction LogRedaction
+
+	// Metrics recorded about calls to the API. A nil metrics disables
+	// recording.
+	metrics Metrics
 
 	// Reuse a single struct instead of allocating one for each service on the
 	// heap.
@@ -61,11 +122,13 @@ func NewClient(clientID string, clientSecret string, httpClient *http.Client) *C
 	baseURL, _ := url.Parse(defaultBaseURL)
 
 	c := &Client{
-		baseURL:      baseURL,
-		client:       httpClient,
-		clientID:     clientID,
-		clientSecret: clientSecret,
-		userAgent:    userAgent,
+		baseURL:     baseURL,
+		client:      httpClient,
+		credentials: StaticCredentials(clientID, clientSecret),
+		userAgent:   userAgent,
+
+		sentReferences: newReferenceCache(defaultReferenceCacheSize, defaultReferenceTTL),
+		logRedaction:   DefaultLogRedaction(),
 	}
 	c.common.client = c
 
@@ -75,7 +138,28 @@ func NewClient(clientID string, clientSecret string, httpClient *http.Client) *C
 	return c
 }
 
-func (c *Client) newRequest(method string, urlPath string, body interface{}) (*http.Request, error) {
+// SetBaseURL points the client at a different Modica API endpoint, such as a
+// staging environment or a fake gateway in tests. The URL must have a
+// trailing slash.
+func (c *Client) SetBaseURL(rawURL string) error {
+	baseURL, err := parseBaseURL(rawURL)
+	if err != nil {
+		return err
+	}
+
+	c.baseURL = baseURL
+	return nil
+}
+
+// newRequest creates an API request bound to ctx. A relative URL path can be
+// provided in urlPath, in which case it is resolved relative to the baseURL
+// of the Client. If specified, the value pointed to by body is JSON encoded
+// and included as the request body.
+func (c *Client) newRequest(ctx context.Context, method string, urlPath string, body interface{}) (*http.Request, error) {
+	if ctx == nil {
+		return nil, errNilContext
+	}
+
 	if !strings.HasSuffix(c.baseURL.Path, "/") {
 		return nil, fmt.Errorf("BaseURL must have a trailing slash, but %q does not", c.baseURL)
 	}
@@ -98,9 +182,14 @@ func (c *Client) newRequest(method string, urlPath string, body interface{}) (*h
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 
 	// Configure Headers
-	req.SetBasicAuth(c.clientID, c.clientSecret)
+	creds, err := c.credentials.Credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(creds.ClientID, creds.ClientSecret)
 	req.Header.Set("Accept", mediaTypeV1)
 
 	if body != nil {
@@ -114,9 +203,91 @@ func (c *Client) newRequest(method string, urlPath string, body interface{}) (*h
 	return req, nil
 }
 
-func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
-	resp, err := c.client.Do(req)
+// do sends an API request and decodes the JSON response into v. The request
+// is cancelled if ctx is cancelled or its deadline is exceeded, in which case
+// the context's error is returned in preference to the transport error.
+// If the client has a retry policy, transient failures are retried according
+// to it. If the API rejects the client's credentials, they are refreshed and
+// the request is retried once with the new credentials.
+func (c *Client) do(ctx context.Context, req *http.Request, v interface{}) (*http.Response, error) {
+	if ctx == nil {
+		return nil, errNilContext
+	}
+
+	info := requestInfoFromContext(ctx)
+	refreshed := false
+	for attempt := 1; ; attempt++ {
+		if info != nil {
+			info.Attempts = attempt
+		}
+
+		attemptReq, err := rewindRequest(ctx, req, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		c.logRequest(ctx, attemptReq, attempt)
+		resp, err := c.doOnce(ctx, attemptReq, v)
+		c.logResponse(ctx, attemptReq, resp, err, time.Since(start))
+		if errors.Is(err, ErrUnauthorized) && !refreshed {
+			refreshed = true
+			if c.refreshCredentials(ctx, req) {
+				continue
+			}
+		}
+
+		if !c.retryPolicy.shouldRetry(ctx, attemptReq, resp, err, attempt) {
+			return resp, err
+		}
+
+		err = sleepContext(ctx, c.retryPolicy.backoff(attempt, resp))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// refreshCredentials refreshes the client's credentials after they were
+// rejected, updating req to use them. It reports whether the credentials
+// changed, and so whether the request is worth retrying.
+func (c *Client) refreshCredentials(ctx context.Context, req *http.Request) bool {
+	if err := c.credentials.Refresh(ctx); err != nil {
+		return false
+	}
+
+	creds, err := c.credentials.Credentials(ctx)
 	if err != nil {
+		return false
+	}
+
+	clientID, clientSecret, _ := req.BasicAuth()
+	if creds.ClientID == clientID && creds.ClientSecret == clientSecret {
+		return false
+	}
+
+	req.SetBasicAuth(creds.ClientID, creds.ClientSecret)
+	return true
+}
+
+// doOnce makes a single attempt at sending an API request. In dry-run mode the
+// request is answered by the client instead of being sent.
+func (c *Client) doOnce(ctx context.Context, req *http.Request, v interface{}) (*http.Response, error) {
+	httpClient := c.client
+	if c.dryRun != nil {
+		httpClient = &http.Client{Transport: c.dryRun}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// If we got an error, and the context has been canceled, the
+		// context's error is probably more useful.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -132,6 +303,54 @@ func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	return resp, err
 }
 
+// rewindRequest returns a copy of req bound to ctx, with a fresh copy of the
+// request body for any attempt after the first.
+func rewindRequest(ctx context.Context, req *http.Request, attempt int) (*http.Request, error) {
+	r := req.WithContext(ctx)
+	if attempt == 1 || req.GetBody == nil {
+		return r, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = body
+
+	return r, nil
+}
+
+// RequestInfo reports how a request made through the client was carried out.
+// Attach one to a context with WithRequestInfo before calling a Context API
+// method, and it will be filled in as the request progresses.
+type RequestInfo struct {
+	// Attempts contains the number of HTTP requests made to the API,
+	// including any retries.
+	Attempts int
+
+	// RateLimitWait contains how long the request was held back by the
+	// client's rate limiter.
+	RateLimitWait time.Duration
+
+	// Deduplicated is set if the client returned the result of an earlier
+	// send with the same reference instead of sending the message again.
+	Deduplicated bool
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of ctx that records details about requests
+// made with it into info.
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// requestInfoFromContext returns the RequestInfo attached to ctx, if any.
+func requestInfoFromContext(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
 // ErrorResponse reports an error caused by an API request.
 type ErrorResponse struct {
 	// Response contains the HTTP response that caused this error
@@ -150,35 +369,46 @@ func (r *ErrorResponse) Error() string {
 
 // CheckResponse checks the API response for errors, and returns uniform errors
 // if present. A response is considered an error if it has a status code outside
-// the 200 range.
+// the 200 range, in which case an *APIError is returned.
 // API error responses are expected to have either no response
 // body, or a JSON response body that maps to ErrorResponse. Any other
 // response body will be silently ignored.
+// Documented error codes can be matched with errors.Is against the ErrNotFound,
+// ErrUnauthorized and ErrMobileGateway* errors.
 func CheckResponse(r *http.Response) error {
 	if code := r.StatusCode; 200 <= code && code <= 299 {
 		return nil
 	}
 
-	switch r.StatusCode {
-	case 401:
-		return ErrUnauthorized
-	case 404:
-		return ErrNotFound
-	}
-
 	errorResponse := &ErrorResponse{Response: r}
 	data, err := ioutil.ReadAll(r.Body)
 	if err == nil && data != nil {
 		json.Unmarshal(data, errorResponse)
 	}
 
-	switch errorResponse.Code {
-	// Return specific Mobile Gateway Error
-	case errCodeSendFailed, errCodeInvalidJson, errCodeMissingAttrib, errCodeInvalidAttrib, errCodeBroadcastLimit, errCode400, errCode422:
-		return mobileGatewayErrorMap[errorResponse.Code]
+	apiErr := &APIError{
+		StatusCode:  r.StatusCode,
+		Code:        errorResponse.Code,
+		Description: errorResponse.ErrorDescription,
+		RequestID:   r.Header.Get(headerRequestID),
+		Response:    r,
+	}
+	if r.Request != nil {
+		apiErr.Method = r.Request.Method
+		if r.Request.URL != nil {
+			apiErr.URL = r.Request.URL.String()
+		}
+	}
+
+	switch r.StatusCode {
+	case http.StatusUnauthorized:
+		apiErr.err = ErrUnauthorized
+	case http.StatusNotFound:
+		apiErr.err = ErrNotFound
+	default:
+		// Match to a specific Mobile Gateway Error, if documented.
+		apiErr.err = mobileGatewayErrorMap[errorResponse.Code]
 	}
 
-	// If we can't match to any documented error codes, return the raw error
-	// object.
-	return err
+	return apiErr
 }
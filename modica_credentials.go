@@ -0,0 +1,225 @@
+package modica
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// EnvClientID contains the default environment variable client IDs are
+	// read from.
+	EnvClientID = "MODICA_CLIENT_ID"
+
+	// EnvClientSecret contains the default environment variable client
+	// secrets are read from.
+	EnvClientSecret = "MODICA_CLIENT_SECRET"
+
+	// DefaultCredentialsPollInterval contains how often a credentials file is
+	// checked for changes by default.
+	DefaultCredentialsPollInterval = 30 * time.Second
+)
+
+// Credentials contains the details used to authenticate with the API.
+type Credentials struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// CredentialsProvider supplies the credentials used to authenticate each
+// request, enabling credentials to be rotated without rebuilding the client.
+// Implementations must be safe for concurrent use.
+type CredentialsProvider interface {
+	// Credentials returns the credentials to authenticate a request with.
+	Credentials(ctx context.Context) (Credentials, error)
+
+	// Refresh reloads the credentials from their source. It is called when
+	// the API rejects the current credentials.
+	Refresh(ctx context.Context) error
+}
+
+// SetCredentialsProvider configures the client to authenticate requests with
+// credentials from provider, replacing the client ID and secret the client
+// was created with. The provider must not be nil.
+func (c *Client) SetCredentialsProvider(provider CredentialsProvider) {
+	c.credentials = provider
+}
+
+// StaticCredentials returns a provider that always supplies the given
+// credentials.
+func StaticCredentials(clientID string, clientSecret string) CredentialsProvider {
+	return staticCredentials{ClientID: clientID, ClientSecret: clientSecret}
+}
+
+type staticCredentials Credentials
+
+func (s staticCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	return Credentials(s), nil
+}
+
+func (s staticCredentials) Refresh(ctx context.Context) error {
+	return nil
+}
+
+// EnvCredentials supplies credentials read from environment variables. The
+// variables are read on first use, and again whenever the credentials are
+// refreshed.
+type EnvCredentials struct {
+	clientIDKey     string
+	clientSecretKey string
+	getenv          func(string) string
+
+	mu     sync.RWMutex
+	cached *Credentials
+}
+
+// NewEnvCredentials returns a provider that reads credentials from the given
+// environment variables. Empty keys default to EnvClientID and
+// EnvClientSecret.
+func NewEnvCredentials(clientIDKey string, clientSecretKey string) *EnvCredentials {
+	if clientIDKey == "" {
+		clientIDKey = EnvClientID
+	}
+	if clientSecretKey == "" {
+		clientSecretKey = EnvClientSecret
+	}
+
+	return &EnvCredentials{
+		clientIDKey:     clientIDKey,
+		clientSecretKey: clientSecretKey,
+		getenv:          os.Getenv,
+	}
+}
+
+// Credentials returns the credentials read from the environment.
+func (e *EnvCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	e.mu.RLock()
+	cached := e.cached
+	e.mu.RUnlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	if err := e.Refresh(ctx); err != nil {
+		return Credentials{}, err
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return *e.cached, nil
+}
+
+// Refresh reads the credentials from the environment again.
+func (e *EnvCredentials) Refresh(ctx context.Context) error {
+	creds := Credentials{
+		ClientID:     e.getenv(e.clientIDKey),
+		ClientSecret: e.getenv(e.clientSecretKey),
+	}
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		return ErrMissingCredentials
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cached = &creds
+	return nil
+}
+
+// FileCredentials supplies credentials read from a JSON file containing
+// client_id and client_secret, as used by the modica command line tool. The
+// file is checked for changes periodically, so credentials rotated on disk are
+// picked up without restarting.
+type FileCredentials struct {
+	path         string
+	pollInterval time.Duration
+	now          func() time.Time
+
+	mu        sync.RWMutex
+	cached    *Credentials
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// NewFileCredentials returns a provider that reads credentials from the file
+// at path, checking it for changes every pollInterval. A pollInterval of zero
+// uses DefaultCredentialsPollInterval.
+func NewFileCredentials(path string, pollInterval time.Duration) *FileCredentials {
+	if pollInterval <= 0 {
+		pollInterval = DefaultCredentialsPollInterval
+	}
+
+	return &FileCredentials{
+		path:         path,
+		pollInterval: pollInterval,
+		now:          time.Now,
+	}
+}
+
+// Credentials returns the credentials read from the file, reloading them if
+// the file has changed since it was last checked.
+func (f *FileCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	f.mu.RLock()
+	cached := f.cached
+	due := f.now().Sub(f.lastCheck) >= f.pollInterval
+	f.mu.RUnlock()
+
+	if cached == nil || due {
+		if err := f.reload(false); err != nil {
+			if cached == nil {
+				return Credentials{}, err
+			}
+
+			// Keep using the last good credentials until the file is
+			// fixed.
+			return *cached, nil
+		}
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return *f.cached, nil
+}
+
+// Refresh reads the credentials from the file again, whether or not it
+// appears to have changed.
+func (f *FileCredentials) Refresh(ctx context.Context) error {
+	return f.reload(true)
+}
+
+// reload reads the file if forced, or if its size or modification time has
+// changed since it was last read.
+func (f *FileCredentials) reload(force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastCheck = f.now()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if !force && f.cached != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return err
+	}
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		return ErrMissingCredentials
+	}
+
+	f.cached = &creds
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
@@ -0,0 +1,161 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rotatingCredentials is a provider whose credentials change when refreshed.
+type rotatingCredentials struct {
+	current   Credentials
+	next      Credentials
+	refreshes int
+}
+
+func (r *rotatingCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	return r.current, nil
+}
+
+func (r *rotatingCredentials) Refresh(ctx context.Context) error {
+	r.refreshes++
+	r.current = r.next
+	return nil
+}
+
+func TestClient_RefreshesCredentialsOnUnauthorized(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	provider := &rotatingCredentials{
+		current: Credentials{ClientID: clientID, ClientSecret: "old"},
+		next:    Credentials{ClientID: clientID, ClientSecret: clientSecret},
+	}
+	client.SetCredentialsProvider(provider)
+
+	requests := 0
+	mux.HandleFunc("/messages/1", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if _, secret, _ := r.BasicAuth(); secret != clientSecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"id":1}`)
+	})
+
+	if _, err := client.MobileGateway.GetMessage(1); err != nil {
+		t.Fatalf("MobileGateway.GetMessage returned error: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("MobileGateway.GetMessage made %d requests, want %d", requests, 2)
+	}
+	if provider.refreshes != 1 {
+		t.Errorf("CredentialsProvider.Refresh called %d times, want %d", provider.refreshes, 1)
+	}
+}
+
+func TestClient_UnauthorizedWithUnchangedCredentials(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	requests := 0
+	mux.HandleFunc("/messages/1", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	if _, err := client.MobileGateway.GetMessage(1); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("MobileGateway.GetMessage returned %+v, want %+v", err, ErrUnauthorized)
+	}
+	if requests != 1 {
+		t.Errorf("MobileGateway.GetMessage made %d requests, want %d", requests, 1)
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	env := map[string]string{}
+	provider := NewEnvCredentials("", "")
+	provider.getenv = func(key string) string { return env[key] }
+
+	if _, err := provider.Credentials(context.Background()); !errors.Is(err, ErrMissingCredentials) {
+		t.Errorf("EnvCredentials.Credentials returned %+v, want %+v", err, ErrMissingCredentials)
+	}
+
+	env[EnvClientID], env[EnvClientSecret] = "foo", "bar"
+	got, err := provider.Credentials(context.Background())
+	if err != nil {
+		t.Fatalf("EnvCredentials.Credentials returned error: %v", err)
+	}
+	if want := (Credentials{ClientID: "foo", ClientSecret: "bar"}); got != want {
+		t.Errorf("EnvCredentials.Credentials returned %+v, want %+v", got, want)
+	}
+
+	// Cached until refreshed.
+	env[EnvClientSecret] = "baz"
+	if got, _ := provider.Credentials(context.Background()); got.ClientSecret != "bar" {
+		t.Errorf("EnvCredentials.Credentials returned secret %q, want %q", got.ClientSecret, "bar")
+	}
+	if err := provider.Refresh(context.Background()); err != nil {
+		t.Fatalf("EnvCredentials.Refresh returned error: %v", err)
+	}
+	if got, _ := provider.Credentials(context.Background()); got.ClientSecret != "baz" {
+		t.Errorf("EnvCredentials.Credentials returned secret %q, want %q", got.ClientSecret, "baz")
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modica")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "credentials.json")
+	writeCredentials := func(secret string, modTime time.Time) {
+		data := fmt.Sprintf(`{"client_id":"foo","client_secret":%q}`, secret)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)
+	provider := NewFileCredentials(path, time.Minute)
+	provider.now = func() time.Time { return now }
+
+	writeCredentials("bar", now)
+	if got, err := provider.Credentials(context.Background()); err != nil || got.ClientSecret != "bar" {
+		t.Fatalf("FileCredentials.Credentials returned %+v, %v, want secret %q", got, err, "bar")
+	}
+
+	// Rotated on disk, but not yet due to be checked.
+	writeCredentials("rotated", now.Add(time.Second))
+	if got, _ := provider.Credentials(context.Background()); got.ClientSecret != "bar" {
+		t.Errorf("FileCredentials.Credentials returned secret %q, want %q", got.ClientSecret, "bar")
+	}
+
+	now = now.Add(time.Minute)
+	if got, _ := provider.Credentials(context.Background()); got.ClientSecret != "rotated" {
+		t.Errorf("FileCredentials.Credentials returned secret %q, want %q", got.ClientSecret, "rotated")
+	}
+
+	// A broken file keeps the last good credentials.
+	if err := ioutil.WriteFile(path, []byte(`{`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute)
+	if got, _ := provider.Credentials(context.Background()); got.ClientSecret != "rotated" {
+		t.Errorf("FileCredentials.Credentials returned secret %q, want %q", got.ClientSecret, "rotated")
+	}
+	if err := provider.Refresh(context.Background()); err == nil {
+		t.Error("FileCredentials.Refresh should have returned an error for a malformed file")
+	}
+}
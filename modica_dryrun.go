@@ -0,0 +1,181 @@
+package modica
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultDryRunMessages limits the number of synthetic messages a client
+	// in dry-run mode remembers for GetMessage.
+	defaultDryRunMessages = 10000
+)
+
+// SetDryRun configures whether the client runs in dry-run mode. In dry-run
+// mode requests are validated, serialised and logged as normal, but are
+// answered by the client itself instead of being sent to the API, so no
+// messages are sent. Created messages are given synthetic IDs and the status
+// "submitted", and the most recent can be retrieved with GetMessage.
+//
+// Requests that would have been made are logged to the client's logger. If
+// the client has no logger, they are logged with the standard library's log
+// package instead.
+func (c *Client) SetDryRun(enabled bool) {
+	if !enabled {
+		c.dryRun = nil
+		return
+	}
+
+	if c.dryRun == nil {
+		c.dryRun = newDryRunTransport()
+	}
+}
+
+// WithDryRun runs the client in dry-run mode. See Client.SetDryRun.
+func WithDryRun() ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.dryRun = true
+		return nil
+	}
+}
+
+// dryRunTransport answers API requests in place of the gateway, remembering
+// the most recent messages created so they can be retrieved.
+type dryRunTransport struct {
+	mu       sync.Mutex
+	size     int
+	lastID   int
+	messages map[int]Message
+}
+
+func newDryRunTransport() *dryRunTransport {
+	return &dryRunTransport{
+		size:     defaultDryRunMessages,
+		messages: make(map[int]Message),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		data, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = data
+	}
+
+	switch {
+	case req.Method == methodPost && strings.HasSuffix(req.URL.Path, "/"+baseBroadcastMessagePath):
+		var message BroadcastMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			return dryRunResponse(req, http.StatusBadRequest, ErrorResponse{Code: "invalid_json", ErrorDescription: err.Error()})
+		}
+
+		responses := make([]BroadcastResponse, len(message.Destinations))
+		for i, destination := range message.Destinations {
+			single := message.Message
+			single.Destination = destination
+			responses[i] = BroadcastResponse{
+				Status:      MessageStatusSubmitted,
+				Destination: destination,
+				ID:          t.store(single),
+			}
+		}
+		return dryRunResponse(req, http.StatusCreated, responses)
+
+	case req.Method == methodPost && strings.HasSuffix(req.URL.Path, "/"+baseMessagePath):
+		var message Message
+		if err := json.Unmarshal(body, &message); err != nil {
+			return dryRunResponse(req, http.StatusBadRequest, ErrorResponse{Code: "invalid_json", ErrorDescription: err.Error()})
+		}
+		return dryRunResponse(req, http.StatusCreated, []int{t.store(message)})
+
+	case req.Method == methodGet && strings.HasSuffix(path.Dir(req.URL.Path), "/"+baseMessagePath):
+		id, err := strconv.Atoi(path.Base(req.URL.Path))
+		if err == nil {
+			t.mu.Lock()
+			message, ok := t.messages[id]
+			t.mu.Unlock()
+			if ok {
+				return dryRunResponse(req, http.StatusOK, message)
+			}
+		}
+	}
+
+	return dryRunResponse(req, http.StatusNotFound, ErrorResponse{Code: "not_found", ErrorDescription: "Not Found"})
+}
+
+// store records a created message, returning its synthetic ID.
+func (t *dryRunTransport) store(message Message) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastID++
+	message.ID = t.lastID
+	message.Status = MessageStatusSubmitted
+	t.messages[message.ID] = message
+
+	// IDs are allocated in order, so the oldest message is the one created
+	// size messages ago.
+	delete(t.messages, message.ID-t.size)
+
+	return message.ID
+}
+
+// dryRunLogger logs the requests of a client in dry-run mode that has no
+// logger of its own, using the standard library's log package.
+type dryRunLogger struct{}
+
+func (dryRunLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	logPairs(msg, args)
+}
+
+func (dryRunLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {
+	logPairs(msg, args)
+}
+
+func (dryRunLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {
+	logPairs(msg, args)
+}
+
+// logPairs logs msg followed by each key and value in args.
+func logPairs(msg string, args []interface{}) {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	log.Print(b.String())
+}
+
+// dryRunResponse returns a response to req with v encoded as its JSON body.
+func dryRunResponse(req *http.Request, statusCode int, v interface{}) (*http.Response, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(statusCode) + " " + http.StatusText(statusCode),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,117 @@
+package modica
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClient_DryRun(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	client.SetDryRun(true)
+	client.SetDefaultRegion(RegionNZ)
+	logger := &recordingLogger{}
+	client.SetLogger(logger)
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Dry run sent a %s request to %s", r.Method, r.URL)
+	})
+
+	messageID, err := client.MobileGateway.CreateMessage(&Message{Destination: "021 123 4567", Content: "Hello"})
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+	if messageID != 1 {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", messageID, 1)
+	}
+
+	// Local validation still runs.
+	if _, err := client.MobileGateway.CreateMessage(&Message{Destination: "X", Content: "Hello"}); err != ErrInvalidPhoneNumber {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrInvalidPhoneNumber)
+	}
+
+	responses, err := client.MobileGateway.CreateBroadcastMessage(&BroadcastMessage{
+		Destinations: []string{"+64211111111", "+64212222222"},
+		Message:      Message{Content: "Hello all"},
+	})
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+	want := []BroadcastResponse{
+		{Status: MessageStatusSubmitted, Destination: "+64211111111", ID: 2},
+		{Status: MessageStatusSubmitted, Destination: "+64212222222", ID: 3},
+	}
+	if !reflect.DeepEqual(responses, want) {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", responses, want)
+	}
+
+	message, err := client.MobileGateway.GetMessage(3)
+	if err != nil {
+		t.Fatalf("MobileGateway.GetMessage returned error: %v", err)
+	}
+	wantMessage := &Message{ID: 3, Destination: "+64212222222", Content: "Hello all", Status: MessageStatusSubmitted}
+	if !reflect.DeepEqual(message, wantMessage) {
+		t.Errorf("MobileGateway.GetMessage returned %+v, want %+v", message, wantMessage)
+	}
+
+	if _, err := client.MobileGateway.GetMessage(99); !errors.Is(err, ErrNotFound) {
+		t.Errorf("MobileGateway.GetMessage returned %+v, want %+v", err, ErrNotFound)
+	}
+
+	if len(logger.records) == 0 || logger.records[0].args["dry_run"] != true || logger.records[0].args["destination"] != "+********567" {
+		t.Errorf("Logger received %+v, want a dry run request to be logged", logger.records)
+	}
+}
+
+func TestWithDryRun(t *testing.T) {
+	client, err := NewClientWithOptions(clientID, clientSecret, WithDryRun())
+	if err != nil {
+		t.Fatalf("NewClientWithOptions returned error: %v", err)
+	}
+	if client.dryRun == nil {
+		t.Error("NewClientWithOptions didn't enable dry-run mode")
+	}
+
+	client.SetDryRun(false)
+	if client.dryRun != nil {
+		t.Error("Client.SetDryRun(false) didn't disable dry-run mode")
+	}
+}
+
+func TestClient_DryRun_DefaultLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	client := NewClient(clientID, clientSecret, nil)
+	client.SetDryRun(true)
+	if _, err := client.MobileGateway.CreateMessage(&Message{Destination: "+64211234567", Content: "Hello"}); err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+
+	if got := buf.String(); !strings.Contains(got, "modica: sending request") || !strings.Contains(got, "dry_run=true") || !strings.Contains(got, "destination=+********567") {
+		t.Errorf("Dry run logged %q, want the request to be logged", got)
+	}
+}
+
+func TestClient_DryRun_Bounded(t *testing.T) {
+	transport := newDryRunTransport()
+	transport.size = 2
+	for i := 0; i < 3; i++ {
+		transport.store(Message{Destination: "+64211234567", Content: "Hello"})
+	}
+
+	if len(transport.messages) != 2 {
+		t.Errorf("dryRunTransport kept %d messages, want %d", len(transport.messages), 2)
+	}
+	if _, ok := transport.messages[1]; ok {
+		t.Error("dryRunTransport kept the oldest message")
+	}
+}
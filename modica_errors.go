@@ -1,6 +1,11 @@
 package modica
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
 
 // Modica Generic API errors
 var (
@@ -10,3 +15,101 @@ var (
 	// ErrNotFound provides a generic 404 not found error
 	ErrNotFound = errors.New("not found")
 )
+
+// Generic client errors, returned by the client itself rather than by the API
+var (
+	// ErrMissingCredentials is returned by a credentials provider when its
+	// source doesn't contain both a client ID and client secret.
+	ErrMissingCredentials = errors.New("client credentials are missing a client id or client secret")
+
+	// ErrTemplateNotFound is returned when a message template isn't
+	// registered in the requested or fallback locale.
+	ErrTemplateNotFound = errors.New("message template not found")
+
+	// ErrTemplateMissingVariable is returned when a message template is
+	// rendered without one of the variables it requires.
+	ErrTemplateMissingVariable = errors.New("message template variable missing")
+
+	// ErrInvalidPhoneNumber is returned when a phone number can't be parsed,
+	// or isn't a possible number in its region.
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+
+	// ErrNoRoute is returned by a router when no rule matches a message and
+	// it has no fallback client.
+	ErrNoRoute = errors.New("no client routes the message")
+)
+
+// errNilContext is returned when a nil context is passed to a request.
+var errNilContext = errors.New("context must be non-nil")
+
+// APIError reports an error response returned by the Modica API. It keeps the
+// details the gateway sent back, and matches the documented error it
+// represents, such as ErrNotFound or ErrMobileGatewaySendFailed, with
+// errors.Is.
+type APIError struct {
+	// StatusCode contains the HTTP status code of the response.
+	StatusCode int
+
+	// Code contains the API error code, if provided.
+	Code string
+
+	// Description contains the API's description of the error, if provided.
+	Description string
+
+	// Method contains the HTTP method of the request that failed.
+	Method string
+
+	// URL contains the URL of the request that failed.
+	URL string
+
+	// RequestID contains the ID the gateway assigned to the request, if
+	// provided.
+	RequestID string
+
+	// Response contains the HTTP response that caused this error.
+	Response *http.Response
+
+	// err contains the documented error matching the response, if any.
+	err error
+}
+
+func (e *APIError) Error() string {
+	msg := e.Description
+	if msg == "" && e.err != nil {
+		msg = e.err.Error()
+	}
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		msg = e.Code + ": " + msg
+	}
+	if e.RequestID != "" {
+		msg += " (request id " + e.RequestID + ")"
+	}
+
+	return fmt.Sprintf("%v %v: %d %v", e.Method, e.URL, e.StatusCode, msg)
+}
+
+// Unwrap returns the documented error matching the response, or nil if the
+// response doesn't match a documented error.
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+// isTransientError reports whether a failed send may succeed if tried again.
+// Only network failures and gateway responses known to be temporary are
+// retried. Anything else, such as an invalid number or a response without a
+// message ID, is treated as permanent, as retrying it would either fail again
+// or risk sending the message twice.
+func isTransientError(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return errors.Is(err, ErrMobileGatewaySendFailed) ||
+			apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,271 @@
+package modica
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Logger receives structured logs of the requests made by the client, as
+// alternating key and value arguments. A *slog.Logger satisfies Logger.
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...interface{})
+	InfoContext(ctx context.Context, msg string, args ...interface{})
+	ErrorContext(ctx context.Context, msg string, args ...interface{})
+}
+
+// ContentLogging controls how message content is logged.
+type ContentLogging int
+
+const (
+	// ContentHashed logs a SHA-256 hash of message content, so identical
+	// messages can be correlated without logging what they say.
+	ContentHashed ContentLogging = iota
+
+	// ContentOmitted doesn't log message content.
+	ContentOmitted
+
+	// ContentPlain logs message content as is. It should only be used in
+	// development.
+	ContentPlain
+)
+
+// LogRedaction configures how sensitive details are redacted from logs.
+type LogRedaction struct {
+	// DestinationDigits contains how many trailing digits of destination
+	// numbers are logged, with the rest masked. A negative value logs
+	// destinations in full.
+	DestinationDigits int
+
+	// Content controls how message content is logged.
+	Content ContentLogging
+
+	// Headers enables logging request headers. The Authorization header is
+	// never logged.
+	Headers bool
+}
+
+// DefaultLogRedaction returns the redaction used unless configured otherwise,
+// which logs the last 3 digits of destinations and hashes content.
+func DefaultLogRedaction() LogRedaction {
+	return LogRedaction{
+		DestinationDigits: 3,
+		Content:           ContentHashed,
+	}
+}
+
+// SetLogger configures the client to log every request made to the API and
+// the response received. A nil logger disables logging.
+func (c *Client) SetLogger(logger Logger) {
+	c.logger = logger
+}
+
+// SetLogRedaction configures how sensitive details are redacted from logs.
+func (c *Client) SetLogRedaction(redaction LogRedaction) {
+	c.logRedaction = redaction
+}
+
+// loggedMessage picks out the fields of a message or broadcast request body
+// that are logged.
+type loggedMessage struct {
+	Destination  string
+	Destinations []string
+	Content      string
+	Reference    string
+}
+
+// loggedSingle picks out the logged fields of a message request body.
+type loggedSingle struct {
+	Destination string `json:"destination"`
+	Content     string `json:"content"`
+	Reference   string `json:"reference"`
+}
+
+// loggedBroadcast picks out the logged fields of a broadcast request body,
+// whose destinations are sent as a list under the same key as a message's.
+type loggedBroadcast struct {
+	Destinations []string `json:"destination"`
+	Content      string   `json:"content"`
+	Reference    string   `json:"reference"`
+}
+
+// decodeLoggedMessage decodes the logged fields of a request body sent to
+// path.
+func decodeLoggedMessage(path string, data []byte) (loggedMessage, error) {
+	if strings.HasSuffix(path, "/"+baseBroadcastMessagePath) {
+		var broadcast loggedBroadcast
+		err := json.Unmarshal(data, &broadcast)
+		return loggedMessage{
+			Destinations: broadcast.Destinations,
+			Content:      broadcast.Content,
+			Reference:    broadcast.Reference,
+		}, err
+	}
+
+	var single loggedSingle
+	err := json.Unmarshal(data, &single)
+	return loggedMessage{
+		Destination: single.Destination,
+		Content:     single.Content,
+		Reference:   single.Reference,
+	}, err
+}
+
+// logRequest logs an attempt at a request before it is sent. In dry-run mode
+// requests are logged even if the client has no logger, as logging them is
+// the only record of what would have been sent.
+func (c *Client) logRequest(ctx context.Context, req *http.Request, attempt int) {
+	logger := c.logger
+	if logger == nil && c.dryRun != nil {
+		logger = dryRunLogger{}
+	}
+	if logger == nil {
+		return
+	}
+
+	args := []interface{}{
+		"method", req.Method,
+		"path", req.URL.Path,
+		"attempt", attempt,
+	}
+	if c.dryRun != nil {
+		args = append(args, "dry_run", true)
+	}
+
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := ioutil.ReadAll(body)
+			body.Close()
+
+			if message, err := decodeLoggedMessage(req.URL.Path, data); err == nil {
+				args = append(args, c.logRedaction.messageArgs(message)...)
+			}
+		}
+	}
+
+	if c.logRedaction.Headers {
+		args = append(args, "headers", redactHeaders(req.Header))
+	}
+
+	logger.DebugContext(ctx, "modica: sending request", args...)
+}
+
+// logResponse logs the outcome of an attempt at a request.
+func (c *Client) logResponse(ctx context.Context, req *http.Request, resp *http.Response, err error, latency time.Duration) {
+	if c.logger == nil {
+		return
+	}
+
+	args := []interface{}{
+		"method", req.Method,
+		"path", req.URL.Path,
+		"latency", latency,
+	}
+	if resp != nil {
+		args = append(args, "status", resp.StatusCode)
+		if requestID := resp.Header.Get(headerRequestID); requestID != "" {
+			args = append(args, "request_id", requestID)
+		}
+	}
+
+	if err == nil {
+		c.logger.InfoContext(ctx, "modica: request succeeded", args...)
+		return
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code != "" {
+		args = append(args, "error_code", apiErr.Code)
+	}
+	args = append(args, "error", err.Error())
+	c.logger.ErrorContext(ctx, "modica: request failed", args...)
+}
+
+// messageArgs returns the logging arguments for a message, with its details
+// redacted.
+func (r LogRedaction) messageArgs(message loggedMessage) []interface{} {
+	var args []interface{}
+	if message.Destination != "" {
+		args = append(args, "destination", r.maskNumber(message.Destination))
+	}
+	if len(message.Destinations) > 0 {
+		args = append(args, "destination_count", len(message.Destinations))
+	}
+	if message.Reference != "" {
+		args = append(args, "reference", r.maskNumbers(message.Reference))
+	}
+
+	switch r.Content {
+	case ContentHashed:
+		if message.Content != "" {
+			sum := sha256.Sum256([]byte(message.Content))
+			args = append(args, "content_sha256", hex.EncodeToString(sum[:]))
+		}
+	case ContentPlain:
+		args = append(args, "content", message.Content)
+	}
+
+	return args
+}
+
+// maskNumber masks all but the configured number of trailing digits of a
+// phone number.
+func (r LogRedaction) maskNumber(number string) string {
+	if r.DestinationDigits < 0 {
+		return number
+	}
+
+	masked := []byte(number)
+	visible := r.DestinationDigits
+	for i := len(masked) - 1; i >= 0; i-- {
+		if masked[i] < '0' || masked[i] > '9' {
+			continue
+		}
+
+		if visible > 0 {
+			visible--
+			continue
+		}
+		masked[i] = '*'
+	}
+
+	return string(masked)
+}
+
+// referenceNumberPattern matches phone numbers written into a reference, such
+// as the original recipient of a redirected message.
+var referenceNumberPattern = regexp.MustCompile(`\+?\d(?:[ -]?\d){7,}`)
+
+// maskNumbers masks every phone number that appears in s, so numbers can't
+// reach the logs through free text such as a message reference.
+func (r LogRedaction) maskNumbers(s string) string {
+	return referenceNumberPattern.ReplaceAllStringFunc(s, r.maskNumber)
+}
+
+// redactHeaders returns the headers of a request as a string, leaving out the
+// Authorization header.
+func redactHeaders(header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		if http.CanonicalHeaderKey(key) == "Authorization" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+": "+strings.Join(header[key], ", "))
+	}
+
+	return strings.Join(pairs, "; ")
+}
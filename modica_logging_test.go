@@ -0,0 +1,149 @@
+package modica
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type logRecord struct {
+	level string
+	msg   string
+	args  map[string]interface{}
+}
+
+// recordingLogger records each log it receives.
+type recordingLogger struct {
+	records []logRecord
+}
+
+func (l *recordingLogger) record(level string, msg string, args []interface{}) {
+	record := logRecord{level: level, msg: msg, args: map[string]interface{}{}}
+	for i := 0; i+1 < len(args); i += 2 {
+		record.args[args[i].(string)] = args[i+1]
+	}
+	l.records = append(l.records, record)
+}
+
+func (l *recordingLogger) DebugContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("debug", msg, args)
+}
+
+func (l *recordingLogger) InfoContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("info", msg, args)
+}
+
+func (l *recordingLogger) ErrorContext(ctx context.Context, msg string, args ...interface{}) {
+	l.record("error", msg, args)
+}
+
+func TestClient_Logging(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	logger := &recordingLogger{}
+	client.SetLogger(logger)
+	client.SetLogRedaction(LogRedaction{DestinationDigits: 3, Headers: true})
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerRequestID, "req-123")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error-desc":"Invalid destination (+642123456789)","error":"invalid_attrib"}`)
+	})
+
+	message := &Message{Destination: "+642123456789", Content: "Your child is unwell"}
+	if _, err := client.MobileGateway.CreateMessage(message); err == nil {
+		t.Fatal("MobileGateway.CreateMessage should have returned an error")
+	}
+
+	if len(logger.records) != 2 {
+		t.Fatalf("Logger received %d records, want %d", len(logger.records), 2)
+	}
+
+	request := logger.records[0]
+	if request.level != "debug" || request.args["method"] != "POST" || request.args["path"] != "/rest/gateway/messages" {
+		t.Errorf("Logger received request record %+v", request)
+	}
+	if got, want := request.args["destination"], "+*********789"; got != want {
+		t.Errorf("Logged destination %v, want %v", got, want)
+	}
+	sum := sha256.Sum256([]byte(message.Content))
+	if got, want := request.args["content_sha256"], hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("Logged content hash %v, want %v", got, want)
+	}
+	if _, ok := request.args["content"]; ok {
+		t.Error("Logger received message content in plain text")
+	}
+	headers, _ := request.args["headers"].(string)
+	if headers == "" || strings.Contains(headers, "Authorization") || strings.Contains(headers, "Basic") {
+		t.Errorf("Logged headers %q, want headers without Authorization", headers)
+	}
+
+	response := logger.records[1]
+	if response.level != "error" {
+		t.Errorf("Logger received response at level %q, want %q", response.level, "error")
+	}
+	if response.args["status"] != http.StatusBadRequest || response.args["error_code"] != "invalid_attrib" || response.args["request_id"] != "req-123" {
+		t.Errorf("Logger received response record %+v", response)
+	}
+	if _, ok := response.args["latency"]; !ok {
+		t.Error("Logger received response record without latency")
+	}
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"status":"success","message":null,"destination":"+64211111111","id":1},{"status":"success","message":null,"destination":"+64212222222","id":2}]`)
+	})
+
+	logger.records = nil
+	broadcast := &BroadcastMessage{
+		Destinations: []string{"+64211111111", "+64212222222"},
+		Message:      Message{Content: "School is closed today", Reference: "closure-1"},
+	}
+	if _, err := client.MobileGateway.CreateBroadcastMessage(broadcast); err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+
+	request = logger.records[0]
+	sum = sha256.Sum256([]byte(broadcast.Content))
+	if request.args["destination_count"] != 2 || request.args["reference"] != "closure-1" || request.args["content_sha256"] != hex.EncodeToString(sum[:]) {
+		t.Errorf("Logger received broadcast request record %+v", request)
+	}
+}
+
+func TestLogRedaction_MaskNumbers(t *testing.T) {
+	tests := []struct {
+		reference string
+		want      string
+	}{
+		{reference: "invoice-42", want: "invoice-42"},
+		{reference: "invoice-42 to:+64212345678", want: "invoice-42 to:+********678"},
+		{reference: "to:021 234 5678", want: "to:*** *** *678"},
+	}
+	for _, test := range tests {
+		if got := DefaultLogRedaction().maskNumbers(test.reference); got != test.want {
+			t.Errorf("LogRedaction.maskNumbers(%q) returned %q, want %q", test.reference, got, test.want)
+		}
+	}
+}
+
+func TestLogRedaction_MaskNumber(t *testing.T) {
+	tests := []struct {
+		digits int
+		number string
+		want   string
+	}{
+		{digits: 3, number: "+642123456789", want: "+*********789"},
+		{digits: 0, number: "+642123456789", want: "+************"},
+		{digits: -1, number: "+642123456789", want: "+642123456789"},
+		{digits: 20, number: "021 234", want: "021 234"},
+	}
+	for _, test := range tests {
+		if got := (LogRedaction{DestinationDigits: test.digits}).maskNumber(test.number); got != test.want {
+			t.Errorf("LogRedaction.maskNumber(%q) with %d digits returned %q, want %q", test.number, test.digits, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the metrics recorded by the client.
+const (
+	// MetricRequestsTotal counts the calls made to the API.
+	MetricRequestsTotal = "modica_requests_total"
+
+	// MetricRequestDuration records how long calls to the API took, in
+	// seconds, including any retries.
+	MetricRequestDuration = "modica_request_duration_seconds"
+
+	// MetricMessagesTotal counts the messages sent, by whether the gateway
+	// accepted them.
+	MetricMessagesTotal = "modica_messages_total"
+)
+
+// Operations metrics are labelled with.
+const (
+	OperationCreate    = "create"
+	OperationBroadcast = "broadcast"
+	OperationGet       = "get"
+)
+
+// Outcomes metrics are labelled with.
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// MetricLabels contains the labels a metric is recorded with.
+type MetricLabels struct {
+	// Operation contains the API call made, such as OperationCreate.
+	Operation string
+
+	// Outcome contains whether the call succeeded, such as OutcomeSuccess.
+	Outcome string
+
+	// ErrorCode contains the gateway's error code if the call failed, such
+	// as "send_failed", or a description of the failure if the gateway
+	// didn't provide one.
+	ErrorCode string
+}
+
+// Metrics receives the metrics recorded by the client. Implementations must
+// be safe for concurrent use.
+type Metrics interface {
+	// AddCounter adds delta to the named counter.
+	AddCounter(name string, labels MetricLabels, delta float64)
+
+	// ObserveHistogram records value in the named histogram.
+	ObserveHistogram(name string, labels MetricLabels, value float64)
+}
+
+// SetMetrics configures the client to record metrics about its calls to the
+// API. A nil metrics disables recording.
+func (c *Client) SetMetrics(metrics Metrics) {
+	c.metrics = metrics
+}
+
+// recordCall records the outcome and latency of a call to the API.
+func (c *Client) recordCall(operation string, start time.Time, err error) {
+	if c.metrics == nil {
+		return
+	}
+
+	labels := MetricLabels{Operation: operation, Outcome: OutcomeSuccess}
+	if err != nil {
+		labels.Outcome = OutcomeError
+		labels.ErrorCode = metricErrorCode(err)
+	}
+
+	c.metrics.AddCounter(MetricRequestsTotal, labels, 1)
+	c.metrics.ObserveHistogram(MetricRequestDuration, labels, time.Since(start).Seconds())
+}
+
+// recordMessages records the number of messages the gateway accepted and
+// rejected in a call.
+func (c *Client) recordMessages(operation string, accepted int, rejected int) {
+	if c.metrics == nil {
+		return
+	}
+
+	if accepted > 0 {
+		c.metrics.AddCounter(MetricMessagesTotal, MetricLabels{Operation: operation, Outcome: OutcomeSuccess}, float64(accepted))
+	}
+	if rejected > 0 {
+		c.metrics.AddCounter(MetricMessagesTotal, MetricLabels{Operation: operation, Outcome: OutcomeError}, float64(rejected))
+	}
+}
+
+// recordBroadcast records the outcome of a broadcast, counting each response
+// as an accepted or rejected message.
+func (c *Client) recordBroadcast(start time.Time, responses []BroadcastResponse, err error) {
+	c.recordCall(OperationBroadcast, start, err)
+	if err != nil {
+		return
+	}
+
+	accepted := 0
+	for _, response := range responses {
+		if !response.Status.IsFailure() {
+			accepted++
+		}
+	}
+	c.recordMessages(OperationBroadcast, accepted, len(responses)-accepted)
+}
+
+// metricErrorCode returns a low cardinality code describing err.
+func metricErrorCode(err error) string {
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code != "":
+		return apiErr.Code
+	case errors.As(err, &apiErr):
+		return strconv.Itoa(apiErr.StatusCode)
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	}
+
+	return "client"
+}
+
+// ExpvarMetrics publishes metrics with the expvar package, in a single map
+// keyed by metric name and labels. Counters are published as floats, and
+// histograms as their count and sum.
+type ExpvarMetrics struct {
+	vars *expvar.Map
+
+	mu sync.Mutex
+}
+
+// NewExpvarMetrics returns metrics published to expvar under name. As with
+// expvar.Publish, it panics if name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{vars: expvar.NewMap(name)}
+}
+
+// AddCounter adds delta to the named counter.
+func (e *ExpvarMetrics) AddCounter(name string, labels MetricLabels, delta float64) {
+	e.vars.AddFloat(metricKey(name, labels), delta)
+}
+
+// ObserveHistogram records value in the named histogram.
+func (e *ExpvarMetrics) ObserveHistogram(name string, labels MetricLabels, value float64) {
+	key := metricKey(name, labels)
+
+	e.mu.Lock()
+	histogram, ok := e.vars.Get(key).(*expvar.Map)
+	if !ok {
+		histogram = new(expvar.Map).Init()
+		e.vars.Set(key, histogram)
+	}
+	e.mu.Unlock()
+
+	histogram.AddFloat("count", 1)
+	histogram.AddFloat("sum", value)
+}
+
+// metricKey returns the name of a metric with its labels, in the Prometheus
+// text format.
+func metricKey(name string, labels MetricLabels) string {
+	return name + braced(formatLabels(labels.pairs()))
+}
+
+// pairs returns the labels that are set, as name and value pairs sorted by
+// name.
+func (l MetricLabels) pairs() [][2]string {
+	var pairs [][2]string
+	if l.ErrorCode != "" {
+		pairs = append(pairs, [2]string{"error_code", l.ErrorCode})
+	}
+	if l.Operation != "" {
+		pairs = append(pairs, [2]string{"operation", l.Operation})
+	}
+	if l.Outcome != "" {
+		pairs = append(pairs, [2]string{"outcome", l.Outcome})
+	}
+
+	return pairs
+}
+
+// formatLabels formats label pairs in the Prometheus text format.
+func formatLabels(pairs [][2]string) string {
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+
+	formatted := make([]string, len(pairs))
+	for i, pair := range pairs {
+		formatted[i] = fmt.Sprintf("%s=\"%s\"", pair[0], escapeLabelValue(pair[1]))
+	}
+
+	return strings.Join(formatted, ",")
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
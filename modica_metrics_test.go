@@ -0,0 +1,111 @@
+package modica
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type recordedMetric struct {
+	name   string
+	labels MetricLabels
+	value  float64
+}
+
+// recordingMetrics records each counter and histogram update.
+type recordingMetrics struct {
+	mu         sync.Mutex
+	counters   []recordedMetric
+	histograms []recordedMetric
+}
+
+func (r *recordingMetrics) AddCounter(name string, labels MetricLabels, delta float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters = append(r.counters, recordedMetric{name: name, labels: labels, value: delta})
+}
+
+func (r *recordingMetrics) ObserveHistogram(name string, labels MetricLabels, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.histograms = append(r.histograms, recordedMetric{name: name, labels: labels})
+}
+
+func TestClient_Metrics(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	metrics := &recordingMetrics{}
+	client.SetMetrics(metrics)
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"status":"success","message":null,"destination":"+64211111111","id":1},`+
+			`{"status":"failure","message":"Invalid destination (X)","destination":"X","id":null}]`)
+	})
+	mux.HandleFunc("/messages/1", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	broadcast := &BroadcastMessage{Destinations: []string{"+64211111111", "X"}, Message: Message{Content: "Hello"}}
+	if _, err := client.MobileGateway.CreateBroadcastMessage(broadcast); err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+	client.MobileGateway.GetMessage(1)
+
+	want := []recordedMetric{
+		{name: MetricRequestsTotal, labels: MetricLabels{Operation: OperationBroadcast, Outcome: OutcomeSuccess}, value: 1},
+		{name: MetricMessagesTotal, labels: MetricLabels{Operation: OperationBroadcast, Outcome: OutcomeSuccess}, value: 1},
+		{name: MetricMessagesTotal, labels: MetricLabels{Operation: OperationBroadcast, Outcome: OutcomeError}, value: 1},
+		{name: MetricRequestsTotal, labels: MetricLabels{Operation: OperationGet, Outcome: OutcomeError, ErrorCode: "404"}, value: 1},
+	}
+	if !reflect.DeepEqual(metrics.counters, want) {
+		t.Errorf("Metrics recorded counters %+v, want %+v", metrics.counters, want)
+	}
+
+	if len(metrics.histograms) != 2 || metrics.histograms[0].name != MetricRequestDuration {
+		t.Errorf("Metrics recorded histograms %+v", metrics.histograms)
+	}
+}
+
+func TestMetricErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: &APIError{StatusCode: 400, Code: "send_failed"}, want: "send_failed"},
+		{err: &APIError{StatusCode: 503}, want: "503"},
+		{err: context.DeadlineExceeded, want: "deadline_exceeded"},
+		{err: ErrInvalidPhoneNumber, want: "client"},
+	}
+	for _, test := range tests {
+		if got := metricErrorCode(test.err); got != test.want {
+			t.Errorf("metricErrorCode(%v) returned %q, want %q", test.err, got, test.want)
+		}
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := NewExpvarMetrics("modica_test")
+	labels := MetricLabels{Operation: OperationCreate, Outcome: OutcomeSuccess}
+	metrics.AddCounter(MetricRequestsTotal, labels, 1)
+	metrics.AddCounter(MetricRequestsTotal, labels, 1)
+	metrics.ObserveHistogram(MetricRequestDuration, labels, 0.5)
+	metrics.ObserveHistogram(MetricRequestDuration, labels, 0.25)
+
+	vars := expvar.Get("modica_test").(*expvar.Map)
+	if got, want := vars.Get(`modica_requests_total{operation="create",outcome="success"}`).String(), "2"; got != want {
+		t.Errorf("ExpvarMetrics counter is %s, want %s", got, want)
+	}
+
+	histogram := vars.Get(`modica_request_duration_seconds{operation="create",outcome="success"}`).(*expvar.Map)
+	if got, want := histogram.Get("count").String(), "2"; got != want {
+		t.Errorf("ExpvarMetrics histogram count is %s, want %s", got, want)
+	}
+	if got, want := histogram.Get("sum").String(), "0.75"; got != want {
+		t.Errorf("ExpvarMetrics histogram sum is %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,215 @@
+package modica
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ClientOption configures a client built by NewClientWithOptions.
+type ClientOption func(*clientConfig) error
+
+// clientConfig collects the options given to NewClientWithOptions, so they can
+// be validated together before the client is built.
+type clientConfig struct {
+	baseURL         *url.URL
+	httpClient      *http.Client
+	userAgentSuffix string
+	timeout         time.Duration
+	defaultSource   string
+	defaultClass    string
+	credentials     CredentialsProvider
+	logger          Logger
+	logRedaction    *LogRedaction
+	metrics         Metrics
+	dryRun          bool
+	allowlist       *DestinationAllowlist
+	retryPolicy     *RetryPolicy
+	messageLimit    RateLimit
+	broadcastLimit  RateLimit
+	defaultRegion   Region
+	schedulePolicy  *SchedulePolicy
+	suppressions    *SuppressionList
+	conversations   *ConversationStore
+	templates       *TemplateRegistry
+}
+
+// WithBaseURL points the client at a different Modica API endpoint, such as a
+// staging environment. The URL must be absolute and have a trailing slash.
+func WithBaseURL(rawURL string) ClientOption {
+	return func(cfg *clientConfig) error {
+		baseURL, err := parseBaseURL(rawURL)
+		if err != nil {
+			return err
+		}
+
+		cfg.baseURL = baseURL
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to communicate with the API.
+// Defaults to http.DefaultClient.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(cfg *clientConfig) error {
+		if httpClient == nil {
+			return errors.New("HTTPClient must not be nil")
+		}
+
+		cfg.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithUserAgentSuffix appends suffix to the User-Agent sent with each request,
+// enabling the calling application to identify itself.
+func WithUserAgentSuffix(suffix string) ClientOption {
+	return func(cfg *clientConfig) error {
+		if strings.ContainsAny(suffix, "\r\n") {
+			return fmt.Errorf("UserAgentSuffix must not contain line breaks, but %q does", suffix)
+		}
+
+		cfg.userAgentSuffix = strings.TrimSpace(suffix)
+		return nil
+	}
+}
+
+// WithTimeout limits how long each attempt at a request may take, including
+// reading the response body. The HTTP client is copied rather than modified.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(cfg *clientConfig) error {
+		if timeout < 0 {
+			return fmt.Errorf("Timeout must not be negative, but is %s", timeout)
+		}
+
+		cfg.timeout = timeout
+		return nil
+	}
+}
+
+// WithDefaultSource sets the Source used for messages that specify neither a
+// Source nor a Class.
+func WithDefaultSource(source string) ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.defaultSource = source
+		return nil
+	}
+}
+
+// WithDefaultClass sets the Class used for messages that specify neither a
+// Source nor a Class.
+func WithDefaultClass(class string) ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.defaultClass = class
+		return nil
+	}
+}
+
+// WithCredentialsProvider authenticates requests with credentials from
+// provider, instead of the client ID and secret passed to
+// NewClientWithOptions.
+func WithCredentialsProvider(provider CredentialsProvider) ClientOption {
+	return func(cfg *clientConfig) error {
+		if provider == nil {
+			return errors.New("CredentialsProvider must not be nil")
+		}
+
+		cfg.credentials = provider
+		return nil
+	}
+}
+
+// WithLogger logs every request made to the API and the response received to
+// logger, redacted according to redaction. A nil redaction uses
+// DefaultLogRedaction.
+func WithLogger(logger Logger, redaction *LogRedaction) ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.logger = logger
+		cfg.logRedaction = redaction
+		return nil
+	}
+}
+
+// WithMetrics records metrics about calls to the API to metrics.
+func WithMetrics(metrics Metrics) ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.metrics = metrics
+		return nil
+	}
+}
+
+// NewClientWithOptions returns a new Modica API client configured with opts.
+// An error is returned if any of the options are invalid, so configuration
+// mistakes are caught when the client is built rather than on the first
+// request.
+func NewClientWithOptions(clientID string, clientSecret string, opts ...ClientOption) (*Client, error) {
+	cfg := &clientConfig{}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.defaultSource != "" && cfg.defaultClass != "" {
+		return nil, errors.New("only one of DefaultSource or DefaultClass may be set")
+	}
+
+	httpClient := cfg.httpClient
+	if cfg.timeout > 0 {
+		if httpClient == nil {
+			httpClient = http.DefaultClient
+		}
+		timeoutClient := *httpClient
+		timeoutClient.Timeout = cfg.timeout
+		httpClient = &timeoutClient
+	}
+
+	c := NewClient(clientID, clientSecret, httpClient)
+	if cfg.baseURL != nil {
+		c.baseURL = cfg.baseURL
+	}
+	if cfg.userAgentSuffix != "" {
+		c.userAgent += " " + cfg.userAgentSuffix
+	}
+	if cfg.credentials != nil {
+		c.credentials = cfg.credentials
+	}
+	c.logger = cfg.logger
+	if cfg.logRedaction != nil {
+		c.logRedaction = *cfg.logRedaction
+	}
+	c.metrics = cfg.metrics
+	c.defaultSource = cfg.defaultSource
+	c.defaultClass = cfg.defaultClass
+	c.SetDryRun(cfg.dryRun)
+	c.allowlist = cfg.allowlist
+	c.retryPolicy = cfg.retryPolicy
+	c.SetRateLimits(cfg.messageLimit, cfg.broadcastLimit)
+	c.defaultRegion = cfg.defaultRegion
+	c.schedulePolicy = cfg.schedulePolicy
+	c.suppressions = cfg.suppressions
+	c.conversations = cfg.conversations
+	c.templates = cfg.templates
+
+	return c, nil
+}
+
+// parseBaseURL parses and validates a base URL for the API.
+func parseBaseURL(rawURL string) (*url.URL, error) {
+	baseURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" || baseURL.Host == "" {
+		return nil, fmt.Errorf("BaseURL must be an absolute http or https URL, but %q is not", baseURL)
+	}
+	if !strings.HasSuffix(baseURL.Path, "/") {
+		return nil, fmt.Errorf("BaseURL must have a trailing slash, but %q does not", baseURL)
+	}
+
+	return baseURL, nil
+}
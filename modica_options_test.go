@@ -0,0 +1,126 @@
+package modica
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewClientWithOptions(t *testing.T) {
+	httpClient := &http.Client{}
+	client, err := NewClientWithOptions(clientID, clientSecret,
+		WithBaseURL("https://staging.example.com/rest/gateway/"),
+		WithHTTPClient(httpClient),
+		WithUserAgentSuffix("school-alerts/1.2"),
+		WithTimeout(10*time.Second),
+		WithDefaultSource("LINCED"),
+	)
+	if err != nil {
+		t.Fatalf("NewClientWithOptions returned error: %v", err)
+	}
+
+	if got, want := client.baseURL.String(), "https://staging.example.com/rest/gateway/"; got != want {
+		t.Errorf("NewClientWithOptions set base url %q, want %q", got, want)
+	}
+	if got, want := client.userAgent, "go-modica school-alerts/1.2"; got != want {
+		t.Errorf("NewClientWithOptions set user agent %q, want %q", got, want)
+	}
+	if client.client == httpClient {
+		t.Error("NewClientWithOptions should copy the HTTP client rather than set its timeout")
+	}
+	if got, want := client.client.Timeout, 10*time.Second; got != want {
+		t.Errorf("NewClientWithOptions set timeout %s, want %s", got, want)
+	}
+	if httpClient.Timeout != 0 {
+		t.Errorf("NewClientWithOptions modified the given HTTP client's timeout")
+	}
+	if got, want := client.defaultSource, "LINCED"; got != want {
+		t.Errorf("NewClientWithOptions set default source %q, want %q", got, want)
+	}
+}
+
+func TestNewClientWithOptions_Features(t *testing.T) {
+	retryPolicy := DefaultRetryPolicy()
+	schedulePolicy := &SchedulePolicy{MaxHorizon: 24 * time.Hour}
+	suppressions := NewSuppressionList(NewMemorySuppressionStore())
+	conversations := NewConversationStore(ConversationOptions{})
+	templates := NewTemplateRegistry(LocaleEnglish)
+
+	client, err := NewClientWithOptions(clientID, clientSecret,
+		WithRetryPolicy(retryPolicy),
+		WithRateLimits(RateLimit{PerSecond: 10}, RateLimit{PerSecond: 1}),
+		WithDefaultRegion(RegionAU),
+		WithSchedulePolicy(schedulePolicy),
+		WithSuppressionList(suppressions),
+		WithConversationStore(conversations),
+		WithTemplateRegistry(templates),
+	)
+	if err != nil {
+		t.Fatalf("NewClientWithOptions returned error: %v", err)
+	}
+
+	if client.retryPolicy != retryPolicy {
+		t.Errorf("NewClientWithOptions set retry policy %+v, want %+v", client.retryPolicy, retryPolicy)
+	}
+	if client.messageLimiter == nil || client.messageLimiter.rate != 10 {
+		t.Errorf("NewClientWithOptions set message limiter %+v, want a rate of %v", client.messageLimiter, 10)
+	}
+	if client.broadcastLimiter == nil || client.broadcastLimiter.rate != 1 {
+		t.Errorf("NewClientWithOptions set broadcast limiter %+v, want a rate of %v", client.broadcastLimiter, 1)
+	}
+	if client.defaultRegion != RegionAU {
+		t.Errorf("NewClientWithOptions set default region %q, want %q", client.defaultRegion, RegionAU)
+	}
+	if client.schedulePolicy != schedulePolicy {
+		t.Errorf("NewClientWithOptions set schedule policy %+v, want %+v", client.schedulePolicy, schedulePolicy)
+	}
+	if client.suppressions != suppressions {
+		t.Error("NewClientWithOptions did not set the suppression list")
+	}
+	if client.conversations != conversations {
+		t.Error("NewClientWithOptions did not set the conversation store")
+	}
+	if client.templates != templates {
+		t.Error("NewClientWithOptions did not set the template registry")
+	}
+}
+
+func TestNewClientWithOptions_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ClientOption
+	}{
+		{name: "base url without trailing slash", opts: []ClientOption{WithBaseURL("https://staging.example.com/rest/gateway")}},
+		{name: "relative base url", opts: []ClientOption{WithBaseURL("/rest/gateway/")}},
+		{name: "unparseable base url", opts: []ClientOption{WithBaseURL("https://staging.example.com:port/")}},
+		{name: "nil http client", opts: []ClientOption{WithHTTPClient(nil)}},
+		{name: "user agent with line break", opts: []ClientOption{WithUserAgentSuffix("app\r\nX-Evil: 1")}},
+		{name: "negative timeout", opts: []ClientOption{WithTimeout(-time.Second)}},
+		{name: "source and class", opts: []ClientOption{WithDefaultSource("LINCED"), WithDefaultClass("mt_message")}},
+		{name: "negative retry delay", opts: []ClientOption{WithRetryPolicy(&RetryPolicy{MaxAttempts: 3, BaseDelay: -time.Second})}},
+		{name: "retry jitter above one", opts: []ClientOption{WithRetryPolicy(&RetryPolicy{MaxAttempts: 3, Jitter: 1.5})}},
+		{name: "unsupported region", opts: []ClientOption{WithDefaultRegion("XX")}},
+		{name: "negative schedule horizon", opts: []ClientOption{WithSchedulePolicy(&SchedulePolicy{MaxHorizon: -time.Hour})}},
+	}
+	for _, test := range tests {
+		if _, err := NewClientWithOptions(clientID, clientSecret, test.opts...); err == nil {
+			t.Errorf("%s: NewClientWithOptions should have returned an error", test.name)
+		}
+	}
+}
+
+func TestMobileGatewayService_CreateMessage_DefaultSource(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.defaultSource = "LINCED"
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":"+64211234567","content":"Hello","source":"LINCED"}`+"\n")
+		fmt.Fprint(w, `[1]`)
+	})
+
+	if _, err := client.MobileGateway.CreateMessage(&Message{Destination: "+64211234567", Content: "Hello"}); err != nil {
+		t.Errorf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package modica
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultLatencyBuckets contains the default upper bounds, in seconds, of the
+// buckets request durations are counted in.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metricHelp contains the help text exposed for each of the client's metrics.
+var metricHelp = map[string]string{
+	MetricRequestsTotal:   "Calls made to the Modica API.",
+	MetricRequestDuration: "Duration of calls made to the Modica API, including retries.",
+	MetricMessagesTotal:   "Messages sent through the Modica API.",
+}
+
+const (
+	metricTypeCounter   = "counter"
+	metricTypeHistogram = "histogram"
+)
+
+// PrometheusMetrics collects metrics in memory, and serves them over HTTP in
+// the Prometheus text exposition format.
+type PrometheusMetrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	metricType string
+	series     map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels MetricLabels
+	value  float64
+
+	// Histogram state, where bucketCounts are not cumulative.
+	bucketCounts []uint64
+	count        uint64
+}
+
+// NewPrometheusMetrics returns an empty set of metrics, whose histograms
+// count observations in the given buckets. If no buckets are given,
+// DefaultLatencyBuckets is used.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	return &PrometheusMetrics{
+		buckets:  sorted,
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// AddCounter adds delta to the named counter.
+func (p *PrometheusMetrics) AddCounter(name string, labels MetricLabels, delta float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.series(name, metricTypeCounter, labels).value += delta
+}
+
+// ObserveHistogram records value in the named histogram.
+func (p *PrometheusMetrics) ObserveHistogram(name string, labels MetricLabels, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	series := p.series(name, metricTypeHistogram, labels)
+	if series.bucketCounts == nil {
+		series.bucketCounts = make([]uint64, len(p.buckets))
+	}
+
+	series.value += value
+	series.count++
+	if i := sort.SearchFloat64s(p.buckets, value); i < len(p.buckets) {
+		series.bucketCounts[i]++
+	}
+}
+
+// series returns the series of a metric with the given labels, creating it if
+// it doesn't exist.
+func (p *PrometheusMetrics) series(name string, metricType string, labels MetricLabels) *metricSeries {
+	family, ok := p.families[name]
+	if !ok {
+		family = &metricFamily{metricType: metricType, series: make(map[string]*metricSeries)}
+		p.families[name] = family
+	}
+
+	key := formatLabels(labels.pairs())
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{labels: labels}
+		family.series[key] = series
+	}
+
+	return series
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(p.exposition())
+}
+
+// exposition returns the metrics in the Prometheus text exposition format.
+func (p *PrometheusMetrics) exposition() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		family := p.families[name]
+		if help, ok := metricHelp[name]; ok {
+			fmt.Fprintf(&buf, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, family.metricType)
+
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			series := family.series[key]
+			if family.metricType == metricTypeCounter {
+				fmt.Fprintf(&buf, "%s%s %s\n", name, braced(key), formatFloat(series.value))
+				continue
+			}
+
+			var cumulative uint64
+			for i, bound := range p.buckets {
+				cumulative += series.bucketCounts[i]
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", name, bucketLabels(series.labels, formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", name, bucketLabels(series.labels, "+Inf"), series.count)
+			fmt.Fprintf(&buf, "%s_sum%s %s\n", name, braced(key), formatFloat(series.value))
+			fmt.Fprintf(&buf, "%s_count%s %d\n", name, braced(key), series.count)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// bucketLabels returns the labels of a histogram bucket, including its upper
+// bound.
+func bucketLabels(labels MetricLabels, bound string) string {
+	return braced(formatLabels(append(labels.pairs(), [2]string{"le", bound})))
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,40 @@
+package modica
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrometheusMetrics_ServeHTTP(t *testing.T) {
+	metrics := NewPrometheusMetrics(0.1, 1)
+	metrics.AddCounter(MetricRequestsTotal, MetricLabels{Operation: OperationCreate, Outcome: OutcomeSuccess}, 1)
+	metrics.AddCounter(MetricRequestsTotal, MetricLabels{Operation: OperationCreate, Outcome: OutcomeError, ErrorCode: `bad "code"`}, 2)
+	metrics.ObserveHistogram(MetricRequestDuration, MetricLabels{Operation: OperationGet, Outcome: OutcomeSuccess}, 0.05)
+	metrics.ObserveHistogram(MetricRequestDuration, MetricLabels{Operation: OperationGet, Outcome: OutcomeSuccess}, 0.5)
+	metrics.ObserveHistogram(MetricRequestDuration, MetricLabels{Operation: OperationGet, Outcome: OutcomeSuccess}, 5)
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got, want := w.Header().Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8"; got != want {
+		t.Errorf("PrometheusMetrics.ServeHTTP returned Content-Type %q, want %q", got, want)
+	}
+
+	body, _ := ioutil.ReadAll(w.Body)
+	want := `# HELP modica_request_duration_seconds Duration of calls made to the Modica API, including retries.
+# TYPE modica_request_duration_seconds histogram
+modica_request_duration_seconds_bucket{le="0.1",operation="get",outcome="success"} 1
+modica_request_duration_seconds_bucket{le="1",operation="get",outcome="success"} 2
+modica_request_duration_seconds_bucket{le="+Inf",operation="get",outcome="success"} 3
+modica_request_duration_seconds_sum{operation="get",outcome="success"} 5.55
+modica_request_duration_seconds_count{operation="get",outcome="success"} 3
+# HELP modica_requests_total Calls made to the Modica API.
+# TYPE modica_requests_total counter
+modica_requests_total{error_code="bad \"code\"",operation="create",outcome="error"} 2
+modica_requests_total{operation="create",outcome="success"} 1
+`
+	if string(body) != want {
+		t.Errorf("PrometheusMetrics.ServeHTTP returned:\n%s\nwant:\n%s", body, want)
+	}
+}
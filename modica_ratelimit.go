@@ -0,0 +1,146 @@
+package modica
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a client-side token bucket, used to keep sends within
+// an account's throughput cap instead of being throttled by the gateway.
+type RateLimit struct {
+	// PerSecond contains the sustained number of requests allowed per second.
+	// A value of zero or less disables the limit.
+	PerSecond float64
+
+	// Burst contains the number of requests that may be made at once before
+	// the sustained rate applies. Values less than 1 are treated as 1.
+	Burst int
+}
+
+// SetRateLimits configures separate rate limits for single message sends and
+// broadcast sends. The limits are shared by every call made through the
+// client, with calls blocking until they are within budget or their context
+// is done. Retrieving messages is not rate limited.
+func (c *Client) SetRateLimits(message RateLimit, broadcast RateLimit) {
+	c.messageLimiter = newTokenBucket(message)
+	c.broadcastLimiter = newTokenBucket(broadcast)
+}
+
+// WithRateLimits limits the rate of single message and broadcast sends. See
+// Client.SetRateLimits.
+func WithRateLimits(message RateLimit, broadcast RateLimit) ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.messageLimit = message
+		cfg.broadcastLimit = broadcast
+		return nil
+	}
+}
+
+// tokenBucket implements a token bucket rate limiter. A nil *tokenBucket
+// allows all requests.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// reservations counts the tokens reserved, so a cancelled reservation
+	// can tell whether a later one was made after it.
+	reservations uint64
+
+	// now enables overriding the clock in tests.
+	now func() time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.PerSecond <= 0 {
+		return nil
+	}
+
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   limit.PerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// reserve takes a token from the bucket, returning how long the caller must
+// wait before the token becomes valid, along with the reservation's number.
+func (b *tokenBucket) reserve() (time.Duration, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	b.reservations++
+	if b.tokens >= 0 {
+		return 0, b.reservations
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), b.reservations
+}
+
+// release returns a reserved token to the bucket, if no later reservation has
+// been made. Later reservations were given delays that assume the token was
+// spent, so returning it would let the next caller share their slot.
+func (b *tokenBucket) release(reservation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if reservation != b.reservations {
+		return
+	}
+
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// wait blocks until a token is available or ctx is done, returning how long
+// it waited.
+func (b *tokenBucket) wait(ctx context.Context) (time.Duration, error) {
+	if b == nil {
+		return 0, nil
+	}
+
+	delay, reservation := b.reserve()
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+	if err := sleepContext(ctx, delay); err != nil {
+		b.release(reservation)
+		return time.Since(start), err
+	}
+
+	return delay, nil
+}
+
+// waitRateLimit blocks on limiter, recording the time spent waiting against
+// any RequestInfo attached to ctx.
+func waitRateLimit(ctx context.Context, limiter *tokenBucket) error {
+	waited, err := limiter.wait(ctx)
+	if info := requestInfoFromContext(ctx); info != nil {
+		info.RateLimitWait += waited
+	}
+
+	return err
+}
@@ -0,0 +1,111 @@
+package modica
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	now := time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(RateLimit{PerSecond: 2, Burst: 2})
+	bucket.now = func() time.Time { return now }
+
+	tests := []struct {
+		advance time.Duration
+		want    time.Duration
+	}{
+		{advance: 0, want: 0},
+		{advance: 0, want: 0},
+		{advance: 0, want: 500 * time.Millisecond},
+		{advance: 0, want: time.Second},
+		{advance: 2 * time.Second, want: 0},
+	}
+	for i, test := range tests {
+		now = now.Add(test.advance)
+		if got, _ := bucket.reserve(); got != test.want {
+			t.Errorf("reserve %d: tokenBucket.reserve returned %v, want %v", i, got, test.want)
+		}
+	}
+}
+
+func TestTokenBucket_WaitCancelled(t *testing.T) {
+	bucket := newTokenBucket(RateLimit{PerSecond: 1})
+	bucket.reserve()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := bucket.wait(ctx); err != context.Canceled {
+		t.Errorf("tokenBucket.wait returned %+v, want %+v", err, context.Canceled)
+	}
+	if bucket.tokens < -0.5 {
+		t.Errorf("tokenBucket.wait should have released its reservation, have %v tokens", bucket.tokens)
+	}
+}
+
+func TestTokenBucket_WaitCancelledBehindLaterReservation(t *testing.T) {
+	now := time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)
+	bucket := newTokenBucket(RateLimit{PerSecond: 1})
+	bucket.now = func() time.Time { return now }
+
+	bucket.reserve()
+	_, cancelled := bucket.reserve()
+	if delay, _ := bucket.reserve(); delay != 2*time.Second {
+		t.Fatalf("tokenBucket.reserve returned %v, want %v", delay, 2*time.Second)
+	}
+
+	// The caller after the cancelled one was given a delay assuming its
+	// token was spent, so the token must not be returned.
+	bucket.release(cancelled)
+	if delay, _ := bucket.reserve(); delay != 3*time.Second {
+		t.Errorf("tokenBucket.reserve returned %v, want %v", delay, 3*time.Second)
+	}
+}
+
+func TestTokenBucket_Nil(t *testing.T) {
+	var bucket *tokenBucket
+	if waited, err := bucket.wait(context.Background()); waited != 0 || err != nil {
+		t.Errorf("nil tokenBucket.wait returned %v, %+v, want 0, nil", waited, err)
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRateLimits(RateLimit{PerSecond: 50}, RateLimit{})
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[123]`)
+	})
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	})
+
+	message := &Message{Destination: "+642123456789", Content: "Hello"}
+	if _, err := client.MobileGateway.CreateMessage(message); err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+
+	var info RequestInfo
+	ctx := WithRequestInfo(context.Background(), &info)
+	if _, err := client.MobileGateway.CreateMessageContext(ctx, message); err != nil {
+		t.Fatalf("MobileGateway.CreateMessageContext returned error: %v", err)
+	}
+	if info.RateLimitWait <= 0 {
+		t.Errorf("RequestInfo.RateLimitWait is %v, want a positive wait", info.RateLimitWait)
+	}
+
+	// Broadcasts have their own, unlimited, budget.
+	var broadcastInfo RequestInfo
+	ctx = WithRequestInfo(context.Background(), &broadcastInfo)
+	broadcast := &BroadcastMessage{Destinations: []string{"+642123456789"}, Message: Message{Content: "Hello"}}
+	if _, err := client.MobileGateway.CreateBroadcastMessageContext(ctx, broadcast); err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessageContext returned error: %v", err)
+	}
+	if broadcastInfo.RateLimitWait != 0 {
+		t.Errorf("RequestInfo.RateLimitWait is %v, want 0", broadcastInfo.RateLimitWait)
+	}
+}
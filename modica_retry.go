@@ -0,0 +1,345 @@
+package modica
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultReferenceCacheSize limits the number of sent message references
+	// remembered for deduplicating retried sends.
+	defaultReferenceCacheSize = 10000
+
+	// defaultReferenceTTL is how long a sent message reference is remembered
+	// for deduplicating retried sends.
+	defaultReferenceTTL = 24 * time.Hour
+)
+
+// RetryPolicy configures how the client retries requests that fail due to a
+// transient gateway or network error.
+//
+// Requests are only retried when it is safe to do so. Responses that show the
+// message was never queued (send_failed, 429 and 503) are always retried.
+// Failures where the message may have been queued, such as a dropped
+// connection or other 5xx response, are only retried for reads. The gateway
+// doesn't deduplicate messages, so retrying a send after such a failure could
+// deliver it twice.
+type RetryPolicy struct {
+	// MaxAttempts contains the maximum number of attempts made for a request,
+	// including the first. Values less than 2 disable retries.
+	MaxAttempts int
+
+	// BaseDelay contains the backoff before the first retry. The backoff is
+	// doubled for each subsequent retry.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the exponential backoff between attempts.
+	MaxDelay time.Duration
+
+	// Jitter contains the fraction, between 0 and 1, of each backoff that is
+	// randomised to avoid many clients retrying in lockstep.
+	Jitter float64
+
+	// MaxRetryAfter caps how long the client will honour a Retry-After
+	// header for. If the server asks for a longer wait, the request is not
+	// retried.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy returns a retry policy suitable for most applications.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:   3,
+		BaseDelay:     500 * time.Millisecond,
+		MaxDelay:      10 * time.Second,
+		Jitter:        0.5,
+		MaxRetryAfter: time.Minute,
+	}
+}
+
+// SetRetryPolicy configures the client to retry transient failures according
+// to policy. A nil policy disables retries.
+func (c *Client) SetRetryPolicy(policy *RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// WithRetryPolicy retries transient failures according to policy. See
+// Client.SetRetryPolicy.
+func WithRetryPolicy(policy *RetryPolicy) ClientOption {
+	return func(cfg *clientConfig) error {
+		if policy != nil {
+			if policy.BaseDelay < 0 || policy.MaxDelay < 0 || policy.MaxRetryAfter < 0 {
+				return errors.New("RetryPolicy delays must not be negative")
+			}
+			if policy.Jitter < 0 || policy.Jitter > 1 {
+				return fmt.Errorf("RetryPolicy.Jitter must be between 0 and 1, but is %v", policy.Jitter)
+			}
+		}
+
+		cfg.retryPolicy = policy
+		return nil
+	}
+}
+
+// shouldRetry reports whether the result of an attempt should be retried.
+func (p *RetryPolicy) shouldRetry(ctx context.Context, req *http.Request, resp *http.Response, err error, attempt int) bool {
+	if p == nil || err == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	idempotent := req.Method == methodGet
+	if resp == nil {
+		// The connection failed, so we don't know whether the request made
+		// it to the gateway.
+		return idempotent
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		retryAfter, ok := parseRetryAfter(resp)
+		return !ok || p.MaxRetryAfter <= 0 || retryAfter <= p.MaxRetryAfter
+
+	case errors.Is(err, ErrMobileGatewaySendFailed):
+		return true
+
+	case resp.StatusCode >= 500:
+		return idempotent
+	}
+
+	return false
+}
+
+// backoff returns how long to wait before the next attempt.
+func (p *RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter, ok := parseRetryAfter(resp); ok {
+			return retryAfter
+		}
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	jitter := p.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		spread := time.Duration(float64(delay) * jitter)
+		delay = delay - spread + time.Duration(rand.Int63n(int64(spread)+1))
+	}
+
+	return delay
+}
+
+// parseRetryAfter parses the Retry-After header of a response, which may be
+// given as either a number of seconds or an HTTP date.
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// sleepContext waits for d, returning early with the context's error if ctx
+// is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// referenceCache remembers the message IDs assigned to recently sent
+// messages, keyed by sentReferenceKey, evicting the oldest entries once full
+// and forgetting entries once they are older than the ttl.
+//
+// A send claims its key before the request is made, so a concurrent send of
+// the same message waits for the outcome rather than going out as well.
+type referenceCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type referenceCacheEntry struct {
+	key       string
+	messageID int
+	sentAt    time.Time
+
+	// pending is closed once a claimed send completes or is abandoned. It is
+	// nil once the message ID is known.
+	pending chan struct{}
+}
+
+func newReferenceCache(size int, ttl time.Duration) *referenceCache {
+	return &referenceCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// referenceKey returns the key a message is deduplicated on, or an empty
+// string if the message has no reference to deduplicate on.
+func referenceKey(message *Message) string {
+	if message.Reference == "" {
+		return ""
+	}
+
+	return message.Destination + "\x00" + message.Reference
+}
+
+// sentReferenceKey returns the key a sent message is remembered by, or an
+// empty string if the message has no reference. The content is part of the
+// key, so a different message reusing a reference isn't mistaken for a
+// repeat.
+func sentReferenceKey(message *Message) string {
+	key := referenceKey(message)
+	if key == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(message.Content))
+	return key + "\x00" + hex.EncodeToString(sum[:])
+}
+
+// claim returns the message ID already sent for key, if there is one.
+// Otherwise it claims the key for the caller, who must then call either
+// complete or abandon. If another send holds the claim, claim waits for it to
+// finish.
+func (rc *referenceCache) claim(ctx context.Context, key string) (messageID int, sent bool, err error) {
+	for {
+		rc.mu.Lock()
+		if elem, ok := rc.entries[key]; ok {
+			entry := elem.Value.(*referenceCacheEntry)
+			if entry.pending != nil {
+				pending := entry.pending
+				rc.mu.Unlock()
+
+				select {
+				case <-ctx.Done():
+					return 0, false, ctx.Err()
+				case <-pending:
+					continue
+				}
+			}
+
+			if rc.now().Sub(entry.sentAt) < rc.ttl {
+				rc.mu.Unlock()
+				return entry.messageID, true, nil
+			}
+
+			rc.order.Remove(elem)
+			delete(rc.entries, key)
+		}
+
+		rc.entries[key] = rc.order.PushBack(&referenceCacheEntry{
+			key:     key,
+			pending: make(chan struct{}),
+		})
+		rc.evict()
+		rc.mu.Unlock()
+
+		return 0, false, nil
+	}
+}
+
+// complete records the message ID for a claimed key, releasing any sends
+// waiting on it.
+func (rc *referenceCache) complete(key string, messageID int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	elem, ok := rc.entries[key]
+	if !ok {
+		return
+	}
+
+	entry := elem.Value.(*referenceCacheEntry)
+	if entry.pending != nil {
+		close(entry.pending)
+		entry.pending = nil
+	}
+	entry.messageID = messageID
+	entry.sentAt = rc.now()
+	rc.order.MoveToBack(elem)
+	rc.evict()
+}
+
+// abandon releases a claimed key without recording a send, so a later send
+// may claim it again.
+func (rc *referenceCache) abandon(key string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	elem, ok := rc.entries[key]
+	if !ok {
+		return
+	}
+
+	entry := elem.Value.(*referenceCacheEntry)
+	if entry.pending == nil {
+		return
+	}
+
+	close(entry.pending)
+	rc.order.Remove(elem)
+	delete(rc.entries, key)
+}
+
+// evict drops the oldest sent entries while the cache is over its size.
+// Claimed entries are kept, as their senders still expect to complete them.
+func (rc *referenceCache) evict() {
+	elem := rc.order.Front()
+	for rc.order.Len() > rc.size && elem != nil {
+		next := elem.Next()
+		if entry := elem.Value.(*referenceCacheEntry); entry.pending == nil {
+			rc.order.Remove(elem)
+			delete(rc.entries, entry.key)
+		}
+		elem = next
+	}
+}
@@ -0,0 +1,313 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRetryPolicy retries quickly and deterministically.
+func testRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    5 * time.Millisecond,
+	}
+}
+
+func TestClient_Retry_ServiceUnavailable(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRetryPolicy(testRetryPolicy())
+
+	calls := 0
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		testBody(t, r, `{"destination":"+642123456789","content":"Hello"}`+"\n")
+		if calls < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `[123]`)
+	})
+
+	var info RequestInfo
+	ctx := WithRequestInfo(context.Background(), &info)
+	got, err := client.MobileGateway.CreateMessageContext(ctx, &Message{Destination: "+642123456789", Content: "Hello"})
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateMessageContext returned error: %v", err)
+	}
+	if got != 123 {
+		t.Errorf("MobileGateway.CreateMessageContext returned %d, want %d", got, 123)
+	}
+	if info.Attempts != 3 {
+		t.Errorf("RequestInfo.Attempts is %d, want %d", info.Attempts, 3)
+	}
+}
+
+func TestClient_Retry_SendFailed(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRetryPolicy(testRetryPolicy())
+
+	calls := 0
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error-desc":"Could not queue message due to an unknown error","error":"send_failed"}`)
+	})
+
+	_, err := client.MobileGateway.CreateMessage(&Message{Destination: "+642123456789", Content: "Hello"})
+	if !errors.Is(err, ErrMobileGatewaySendFailed) {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrMobileGatewaySendFailed)
+	}
+	if calls != 3 {
+		t.Errorf("MobileGateway.CreateMessage made %d attempts, want %d", calls, 3)
+	}
+}
+
+func TestClient_Retry_AmbiguousFailureWithoutReference(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRetryPolicy(testRetryPolicy())
+
+	calls := 0
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	client.MobileGateway.CreateMessage(&Message{Destination: "+642123456789", Content: "Hello"})
+	if calls != 1 {
+		t.Errorf("MobileGateway.CreateMessage made %d attempts, want %d", calls, 1)
+	}
+}
+
+func TestClient_Retry_AmbiguousFailureWithReference(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRetryPolicy(testRetryPolicy())
+
+	calls := 0
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// The message may have been queued before the failure, so
+			// retrying could send it twice.
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, `[123]`)
+	})
+
+	message := &Message{Destination: "+642123456789", Content: "Hello", Reference: "notice-1"}
+	if _, err := client.MobileGateway.CreateMessage(message); err == nil {
+		t.Fatal("MobileGateway.CreateMessage should have returned an error")
+	}
+	if calls != 1 {
+		t.Errorf("MobileGateway.CreateMessage made %d attempts, want %d", calls, 1)
+	}
+
+	got, err := client.MobileGateway.CreateMessage(message)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+	if got != 123 {
+		t.Errorf("MobileGateway.CreateMessage returned %d, want %d", got, 123)
+	}
+
+	// Sending the same reference again must not double send.
+	var info RequestInfo
+	ctx := WithRequestInfo(context.Background(), &info)
+	got, err = client.MobileGateway.CreateMessageContext(ctx, message)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateMessageContext returned error: %v", err)
+	}
+	if got != 123 {
+		t.Errorf("MobileGateway.CreateMessageContext returned %d, want %d", got, 123)
+	}
+	if !info.Deduplicated {
+		t.Error("RequestInfo.Deduplicated should be set for a repeated reference")
+	}
+	if calls != 2 {
+		t.Errorf("MobileGateway.CreateMessage made %d requests, want %d", calls, 2)
+	}
+}
+
+func TestClient_Retry_NotFoundIsNotRetried(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRetryPolicy(testRetryPolicy())
+
+	calls := 0
+	mux.HandleFunc("/messages/321", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	_, err := client.MobileGateway.GetMessage(321)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("MobileGateway.GetMessage returned %+v, want %+v", err, ErrNotFound)
+	}
+	if calls != 1 {
+		t.Errorf("MobileGateway.GetMessage made %d attempts, want %d", calls, 1)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := &RetryPolicy{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  time.Second,
+	}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 100 * time.Millisecond},
+		{attempt: 2, want: 200 * time.Millisecond},
+		{attempt: 3, want: 400 * time.Millisecond},
+		{attempt: 5, want: time.Second},
+	}
+	for _, test := range tests {
+		if got := policy.backoff(test.attempt, nil); got != test.want {
+			t.Errorf("RetryPolicy.backoff(%d) returned %v, want %v", test.attempt, got, test.want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		got := policy.backoff(1, nil)
+		if got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Fatalf("RetryPolicy.backoff with jitter returned %v, want between 50ms and 100ms", got)
+		}
+	}
+}
+
+func TestClient_Retry_ReferenceReusedWithNewContent(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRetryPolicy(testRetryPolicy())
+
+	calls := 0
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, `[%d]`, calls)
+	})
+
+	first := &Message{Destination: "+642123456789", Content: "Hello", Reference: "notice-1"}
+	if _, err := client.MobileGateway.CreateMessage(first); err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+
+	second := &Message{Destination: "+642123456789", Content: "Goodbye", Reference: "notice-1"}
+	got, err := client.MobileGateway.CreateMessage(second)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+	if got != 2 {
+		t.Errorf("MobileGateway.CreateMessage returned %d, want %d", got, 2)
+	}
+	if calls != 2 {
+		t.Errorf("MobileGateway.CreateMessage made %d requests, want %d", calls, 2)
+	}
+}
+
+func TestClient_Retry_ReferenceExpires(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRetryPolicy(testRetryPolicy())
+
+	now := time.Now()
+	client.sentReferences.now = func() time.Time { return now }
+
+	calls := 0
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, `[%d]`, calls)
+	})
+
+	message := &Message{Destination: "+642123456789", Content: "Hello", Reference: "notice-1"}
+	if _, err := client.MobileGateway.CreateMessage(message); err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+
+	now = now.Add(defaultReferenceTTL)
+	got, err := client.MobileGateway.CreateMessage(message)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+	if got != 2 {
+		t.Errorf("MobileGateway.CreateMessage returned %d, want %d", got, 2)
+	}
+}
+
+func TestClient_Retry_ConcurrentReference(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetRetryPolicy(testRetryPolicy())
+
+	var calls int32
+	release := make(chan struct{})
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		fmt.Fprint(w, `[123]`)
+	})
+
+	const senders = 5
+	var wg sync.WaitGroup
+	ids := make(chan int, senders)
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			message := &Message{Destination: "+642123456789", Content: "Hello", Reference: "notice-1"}
+			id, err := client.MobileGateway.CreateMessage(message)
+			if err != nil {
+				t.Errorf("MobileGateway.CreateMessage returned error: %v", err)
+			}
+			ids <- id
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(ids)
+
+	for id := range ids {
+		if id != 123 {
+			t.Errorf("MobileGateway.CreateMessage returned %d, want %d", id, 123)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("MobileGateway.CreateMessage made %d requests, want %d", got, 1)
+	}
+}
+
+func TestReferenceCache_AbandonReleasesClaim(t *testing.T) {
+	cache := newReferenceCache(10, time.Hour)
+	ctx := context.Background()
+
+	if _, sent, err := cache.claim(ctx, "key"); err != nil || sent {
+		t.Fatalf("referenceCache.claim returned sent %v, error %v, want a claim", sent, err)
+	}
+
+	waited := make(chan bool)
+	go func() {
+		_, sent, _ := cache.claim(ctx, "key")
+		waited <- sent
+	}()
+
+	cache.abandon("key")
+	if sent := <-waited; sent {
+		t.Error("referenceCache.claim reported an abandoned key as sent")
+	}
+}
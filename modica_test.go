@@ -1,11 +1,11 @@
 package modica
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"os"
 	"testing"
 )
@@ -49,9 +49,10 @@ func setup() (client *Client, mux *http.ServeMux, serverURL string, teardown fun
 
 	// client is the Modica client being tested and is configured to use the
 	// test server.
-	client = NewClient(clientID, clientSecret, nil)
-	baseURL, _ := url.Parse(server.URL + baseURLPath + "/")
-	client.baseURL = baseURL
+	client, err := NewClientWithOptions(clientID, clientSecret, WithBaseURL(server.URL+baseURLPath+"/"))
+	if err != nil {
+		panic(err)
+	}
 
 	return client, mux, server.URL, server.Close
 }
@@ -77,3 +78,77 @@ func testBody(t *testing.T, r *http.Request, want string) {
 		t.Errorf("request Body is %s, want %s", got, want)
 	}
 }
+
+func TestClient_SetBaseURL(t *testing.T) {
+	client := NewClient(clientID, clientSecret, nil)
+
+	if err := client.SetBaseURL("https://staging.example.com/rest/gateway"); err == nil {
+		t.Error("Client.SetBaseURL should reject a base url without a trailing slash")
+	}
+
+	want := "https://staging.example.com/rest/gateway/"
+	if err := client.SetBaseURL(want); err != nil {
+		t.Fatalf("Client.SetBaseURL returned error: %v", err)
+	}
+	if got := client.baseURL.String(); got != want {
+		t.Errorf("Client.SetBaseURL set %q, want %q", got, want)
+	}
+}
+
+func TestCheckResponse_APIError(t *testing.T) {
+	client, mux, serverURL, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(headerRequestID, "req-123")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error-desc":"Missing required destination attribute","error":"missing_attrib"}`)
+	})
+
+	_, err := client.MobileGateway.CreateMessage(&Message{Content: "Hello"})
+	if !errors.Is(err, ErrMobileGatewayMissingAttribute) {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrMobileGatewayMissingAttribute)
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("MobileGateway.CreateMessage returned %T, want *APIError", err)
+	}
+
+	want := &APIError{
+		StatusCode:  http.StatusBadRequest,
+		Code:        "missing_attrib",
+		Description: "Missing required destination attribute",
+		Method:      "POST",
+		URL:         serverURL + baseURLPath + "/messages",
+		RequestID:   "req-123",
+	}
+	if apiErr.StatusCode != want.StatusCode || apiErr.Code != want.Code ||
+		apiErr.Description != want.Description || apiErr.Method != want.Method ||
+		apiErr.URL != want.URL || apiErr.RequestID != want.RequestID {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", apiErr, want)
+	}
+}
+
+func TestCheckResponse_UnknownError(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/messages/123", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error-desc":"Something exploded","error":"kaboom"}`)
+	})
+
+	_, err := client.MobileGateway.GetMessage(123)
+	if err == nil {
+		t.Fatal("MobileGateway.GetMessage returned no error for an undocumented error response")
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("MobileGateway.GetMessage returned %T, want *APIError", err)
+	}
+	if apiErr.Code != "kaboom" || apiErr.Description != "Something exploded" || apiErr.Unwrap() != nil {
+		t.Errorf("MobileGateway.GetMessage returned %+v", apiErr)
+	}
+}
@@ -0,0 +1,406 @@
+// Package modicatest provides an in-memory fake of Modica's Mobile Gateway
+// API for use in integration tests.
+//
+// The fake allocates real message IDs, validates requests the same way the
+// gateway does, returns the documented error codes and records every request
+// it receives so tests can make assertions against them.
+package modicatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewhartstonge/go-modica"
+)
+
+const (
+	// ClientID contains the client ID the fake gateway accepts.
+	ClientID = "modicatest"
+
+	// ClientSecret contains the client secret the fake gateway accepts.
+	ClientSecret = "modicatest-secret"
+
+	// BasePath contains the path the fake gateway serves the API under.
+	BasePath = "/rest/gateway/"
+
+	// DefaultBroadcastLimit contains the default maximum number of
+	// destinations accepted in a single broadcast.
+	DefaultBroadcastLimit = 1000
+)
+
+// Error codes as documented by the Mobile Gateway API.
+const (
+	ErrCodeSendFailed     = "send_failed"
+	ErrCodeInvalidJSON    = "invalid_json"
+	ErrCodeMissingAttrib  = "missing_attrib"
+	ErrCodeInvalidAttrib  = "invalid_attrib"
+	ErrCodeBroadcastLimit = "broadcast_limit"
+	ErrCode400            = "400"
+	ErrCode422            = "422"
+)
+
+// errorStatusCodes maps each error code to the HTTP status the gateway
+// responds with.
+var errorStatusCodes = map[string]int{
+	ErrCodeSendFailed:     http.StatusBadRequest,
+	ErrCodeInvalidJSON:    http.StatusBadRequest,
+	ErrCodeMissingAttrib:  http.StatusBadRequest,
+	ErrCodeInvalidAttrib:  http.StatusBadRequest,
+	ErrCodeBroadcastLimit: http.StatusBadRequest,
+	ErrCode400:            http.StatusBadRequest,
+	ErrCode422:            http.StatusUnprocessableEntity,
+}
+
+// internationalNumber matches an international format mobile number.
+var internationalNumber = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// Request records a request received by the fake gateway.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Server implements a stateful fake Mobile Gateway.
+type Server struct {
+	// URL contains the base URL of the fake gateway API, with a trailing
+	// slash, suitable for passing to modica.Client.SetBaseURL.
+	URL string
+
+	server *httptest.Server
+
+	mu             sync.Mutex
+	nextID         int
+	messages       map[int]modica.Message
+	requests       []Request
+	failures       []string
+	broadcastLimit int
+	now            func() time.Time
+}
+
+// NewServer starts and returns a new fake gateway. The caller should call
+// Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		nextID:         1,
+		messages:       make(map[int]modica.Message),
+		broadcastLimit: DefaultBroadcastLimit,
+		now:            time.Now,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(BasePath, s.serveAPI)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL + BasePath
+
+	return s
+}
+
+// Client returns a new modica.Client configured with the fake's credentials
+// and pointed at the fake gateway.
+func (s *Server) Client() *modica.Client {
+	client, err := modica.NewClientWithOptions(ClientID, ClientSecret,
+		modica.WithHTTPClient(s.server.Client()),
+		modica.WithBaseURL(s.URL),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("modicatest: invalid client configuration: %v", err))
+	}
+
+	return client
+}
+
+// Close shuts down the fake gateway.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetBroadcastLimit sets the maximum number of destinations accepted in a
+// single broadcast.
+func (s *Server) SetBroadcastLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.broadcastLimit = limit
+}
+
+// SetNow overrides the clock used to validate scheduled timestamps.
+func (s *Server) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
+}
+
+// FailNext queues an error code to be returned for the next send, regardless
+// of whether the request is valid. Queued failures are returned in order.
+func (s *Server) FailNext(errCode string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, errCode)
+}
+
+// Requests returns a copy of every request received by the fake gateway, in
+// the order they were received.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// Message returns the message stored under the given ID.
+func (s *Server) Message(messageID int) (modica.Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, ok := s.messages[messageID]
+	return message, ok
+}
+
+// SetStatus sets the delivery status reported for the message stored under
+// the given ID, reporting whether the message exists.
+func (s *Server) SetStatus(messageID int, status modica.MessageStatus) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, ok := s.messages[messageID]
+	if !ok {
+		return false
+	}
+
+	message.Status = status
+	s.messages[messageID] = message
+	return true
+}
+
+// Messages returns every message accepted by the fake gateway, ordered by
+// message ID.
+func (s *Server) Messages() []modica.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := make([]modica.Message, 0, len(s.messages))
+	for id := 1; id < s.nextID; id++ {
+		if message, ok := s.messages[id]; ok {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	path := strings.TrimPrefix(r.URL.Path, BasePath)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   path,
+		Header: r.Header,
+		Body:   body,
+	})
+
+	if id, secret, ok := r.BasicAuth(); !ok || id != ClientID || secret != ClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && path == "messages":
+		s.createMessage(w, body)
+
+	case r.Method == http.MethodPost && path == "messages/broadcast":
+		s.createBroadcastMessage(w, body)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "messages/"):
+		s.getMessage(w, strings.TrimPrefix(path, "messages/"))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *Server) createMessage(w http.ResponseWriter, body []byte) {
+	if s.popFailure(w) {
+		return
+	}
+
+	var message modica.Message
+	if err := json.Unmarshal(body, &message); err != nil {
+		writeError(w, ErrCodeInvalidJSON, "Invalid JSON data in the request body")
+		return
+	}
+
+	if message.Destination == "" {
+		writeError(w, ErrCodeMissingAttrib, "Missing required destination attribute")
+		return
+	}
+	if !internationalNumber.MatchString(message.Destination) {
+		writeError(w, ErrCodeInvalidAttrib, "Invalid destination ("+message.Destination+")")
+		return
+	}
+	if !s.validMessage(w, &message) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, []int{s.store(message)})
+}
+
+// broadcastResponse mirrors the gateway's broadcast response, where ID and
+// message are null when not applicable.
+type broadcastResponse struct {
+	Status      modica.MessageStatus `json:"status"`
+	Message     *string              `json:"message"`
+	Destination string               `json:"destination"`
+	ID          *int                 `json:"id"`
+}
+
+func (s *Server) createBroadcastMessage(w http.ResponseWriter, body []byte) {
+	if s.popFailure(w) {
+		return
+	}
+
+	var broadcast modica.BroadcastMessage
+	if err := json.Unmarshal(body, &broadcast); err != nil {
+		writeError(w, ErrCodeInvalidJSON, "Invalid JSON data in the request body")
+		return
+	}
+
+	if len(broadcast.Destinations) == 0 {
+		writeError(w, ErrCodeMissingAttrib, "Missing required destination attribute")
+		return
+	}
+	if len(broadcast.Destinations) > s.broadcastLimit {
+		writeError(w, ErrCodeBroadcastLimit, fmt.Sprintf("Broadcast limit of %d destinations has been exceeded", s.broadcastLimit))
+		return
+	}
+	if !s.validMessage(w, &broadcast.Message) {
+		return
+	}
+
+	responses := make([]broadcastResponse, 0, len(broadcast.Destinations))
+	for _, destination := range broadcast.Destinations {
+		if !internationalNumber.MatchString(destination) {
+			desc := "Invalid destination (" + destination + ")"
+			responses = append(responses, broadcastResponse{
+				Status:      modica.MessageStatusFailure,
+				Message:     &desc,
+				Destination: destination,
+			})
+			continue
+		}
+
+		message := broadcast.Message
+		message.Destination = destination
+		id := s.store(message)
+		responses = append(responses, broadcastResponse{
+			Status:      modica.MessageStatusSuccess,
+			Destination: destination,
+			ID:          &id,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, responses)
+}
+
+func (s *Server) getMessage(w http.ResponseWriter, rawID string) {
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	message, ok := s.messages[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, message)
+}
+
+// validMessage validates the attributes shared by single and broadcast
+// messages, writing an error response if they are invalid.
+func (s *Server) validMessage(w http.ResponseWriter, message *modica.Message) bool {
+	if message.Content == "" {
+		writeError(w, ErrCodeMissingAttrib, "Missing required content attribute")
+		return false
+	}
+	if message.Source != "" && message.Class != "" {
+		writeError(w, ErrCodeInvalidAttrib, "Only one of source or class may be provided")
+		return false
+	}
+	if message.SMSClass < 0 || message.SMSClass > 3 {
+		writeError(w, ErrCodeInvalidAttrib, "Invalid sms_class attribute value")
+		return false
+	}
+
+	if message.Scheduled != "" {
+		scheduled, err := time.Parse(time.RFC3339, message.Scheduled)
+		if err != nil {
+			writeError(w, ErrCode400, "Invalid scheduled timestamp (must be RFC3339)")
+			return false
+		}
+		if scheduled.Before(s.now()) {
+			writeError(w, ErrCode422, "Invalid scheduled timestamp (must not be in the past)")
+			return false
+		}
+	}
+
+	return true
+}
+
+// store saves a message under a newly allocated ID, returning the ID.
+func (s *Server) store(message modica.Message) int {
+	id := s.nextID
+	s.nextID++
+
+	message.ID = id
+	s.messages[id] = message
+	return id
+}
+
+// popFailure writes the next queued failure, if any.
+func (s *Server) popFailure(w http.ResponseWriter) bool {
+	if len(s.failures) == 0 {
+		return false
+	}
+
+	errCode := s.failures[0]
+	s.failures = s.failures[1:]
+	writeError(w, errCode, "Injected failure")
+	return true
+}
+
+func writeError(w http.ResponseWriter, errCode string, description string) {
+	status, ok := errorStatusCodes[errCode]
+	if !ok {
+		status = http.StatusBadRequest
+	}
+
+	writeJSON(w, status, map[string]string{
+		"error":      errCode,
+		"error-desc": description,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
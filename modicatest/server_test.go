@@ -0,0 +1,165 @@
+package modicatest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matthewhartstonge/go-modica"
+)
+
+func TestServer_CreateAndGetMessage(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	payload := &modica.Message{
+		Destination: "+642123456789",
+		Content:     "Hi, this is a test message to ensure you are texting correctly",
+		Reference:   "alt-reference",
+	}
+	id, err := client.MobileGateway.CreateMessage(payload)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+	if id != 1 {
+		t.Errorf("MobileGateway.CreateMessage returned %d, want %d", id, 1)
+	}
+
+	got, err := client.MobileGateway.GetMessage(id)
+	if err != nil {
+		t.Fatalf("MobileGateway.GetMessage returned error: %v", err)
+	}
+	want := *payload
+	want.ID = id
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("MobileGateway.GetMessage returned %+v, want %+v", *got, want)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Server recorded %d requests, want %d", len(requests), 2)
+	}
+	if requests[0].Method != "POST" || requests[0].Path != "messages" {
+		t.Errorf("Server recorded %s %s, want POST messages", requests[0].Method, requests[0].Path)
+	}
+}
+
+func TestServer_GetMessage_NotFound(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	_, err := server.Client().MobileGateway.GetMessage(321)
+	if !errors.Is(err, modica.ErrNotFound) {
+		t.Errorf("MobileGateway.GetMessage returned %+v, want %+v", err, modica.ErrNotFound)
+	}
+}
+
+func TestServer_Unauthorized(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	client := modica.NewClient("foo", "bar", nil)
+	client.SetBaseURL(server.URL)
+
+	_, err := client.MobileGateway.GetMessage(1)
+	if !errors.Is(err, modica.ErrUnauthorized) {
+		t.Errorf("MobileGateway.GetMessage returned %+v, want %+v", err, modica.ErrUnauthorized)
+	}
+}
+
+func TestServer_CreateMessage_Errors(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetNow(func() time.Time {
+		return time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)
+	})
+	client := server.Client()
+
+	tests := []struct {
+		name    string
+		message *modica.Message
+		want    error
+	}{
+		{
+			name:    "missing destination",
+			message: &modica.Message{Content: "Hello"},
+			want:    modica.ErrMobileGatewayMissingAttribute,
+		},
+		{
+			name:    "invalid destination",
+			message: &modica.Message{Destination: "LOL", Content: "Hello"},
+			want:    modica.ErrMobileGatewayInvalidAttribute,
+		},
+		{
+			name:    "source and class",
+			message: &modica.Message{Destination: "+642123456789", Content: "Hello", Source: "TEST", Class: "mt_message"},
+			want:    modica.ErrMobileGatewayInvalidAttribute,
+		},
+		{
+			name:    "invalid timestamp format",
+			message: &modica.Message{Destination: "+642123456789", Content: "Hello", Scheduled: "tomorrow"},
+			want:    modica.ErrMobileGatewayInvalidTimestampFormat,
+		},
+		{
+			name:    "timestamp in the past",
+			message: &modica.Message{Destination: "+642123456789", Content: "Hello", Scheduled: "2017-05-05T09:00:00Z"},
+			want:    modica.ErrMobileGatewayInvalidTimestamp,
+		},
+	}
+	for _, test := range tests {
+		if _, err := client.MobileGateway.CreateMessage(test.message); !errors.Is(err, test.want) {
+			t.Errorf("%s: MobileGateway.CreateMessage returned %+v, want %+v", test.name, err, test.want)
+		}
+	}
+
+	if messages := server.Messages(); len(messages) != 0 {
+		t.Errorf("Server stored %d invalid messages, want none", len(messages))
+	}
+}
+
+func TestServer_FailNext(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.FailNext(ErrCodeSendFailed)
+	client := server.Client()
+
+	message := &modica.Message{Destination: "+642123456789", Content: "Hello"}
+	if _, err := client.MobileGateway.CreateMessage(message); !errors.Is(err, modica.ErrMobileGatewaySendFailed) {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, modica.ErrMobileGatewaySendFailed)
+	}
+	if _, err := client.MobileGateway.CreateMessage(message); err != nil {
+		t.Errorf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+}
+
+func TestServer_CreateBroadcastMessage(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	payload := &modica.BroadcastMessage{
+		Destinations: []string{"+61234567890", "X"},
+		Message: modica.Message{
+			Content: "Hello",
+		},
+	}
+	got, err := client.MobileGateway.CreateBroadcastMessage(payload)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+
+	want := []modica.BroadcastResponse{
+		{Status: "success", Destination: "+61234567890", ID: 1},
+		{Status: "failure", Message: "Invalid destination (X)", Destination: "X"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", got, want)
+	}
+
+	server.SetBroadcastLimit(1)
+	if _, err := client.MobileGateway.CreateBroadcastMessage(payload); !errors.Is(err, modica.ErrMobileGatewayBroadcastLimit) {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", err, modica.ErrMobileGatewayBroadcastLimit)
+	}
+}
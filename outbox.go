@@ -0,0 +1,598 @@
+package modica
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOutboxWorkers contains the default number of messages an outbox
+	// delivers at once.
+	DefaultOutboxWorkers = 4
+
+	// DefaultOutboxMaxAttempts contains the default number of times an outbox
+	// attempts to deliver a message before giving up.
+	DefaultOutboxMaxAttempts = 5
+
+	// DefaultOutboxRetryDelay contains the default wait before an outbox
+	// retries a message. The wait doubles with each attempt.
+	DefaultOutboxRetryDelay = 5 * time.Second
+
+	// DefaultOutboxRetention contains how long an outbox keeps sent and
+	// failed entries by default.
+	DefaultOutboxRetention = 7 * 24 * time.Hour
+
+	// DefaultOutboxCompactInterval contains how often a running outbox prunes
+	// and compacts its journal by default.
+	DefaultOutboxCompactInterval = time.Hour
+
+	// maxOutboxRetryDelay caps the wait between attempts to deliver a message.
+	maxOutboxRetryDelay = 5 * time.Minute
+)
+
+// OutboxState describes where an outbox entry is in its delivery.
+type OutboxState string
+
+const (
+	// OutboxPending marks an entry that is waiting to be delivered.
+	OutboxPending OutboxState = "pending"
+
+	// OutboxSent marks an entry that was accepted by the gateway.
+	OutboxSent OutboxState = "sent"
+
+	// OutboxFailed marks an entry that could not be delivered.
+	OutboxFailed OutboxState = "failed"
+)
+
+// OutboxEntry records a message queued in an outbox, and the outcome of
+// delivering it.
+type OutboxEntry struct {
+	ID      uint64      `json:"id"`
+	Message Message     `json:"message"`
+	State   OutboxState `json:"state"`
+
+	// MessageID contains the ID the gateway assigned the message once sent.
+	MessageID int `json:"message_id,omitempty"`
+
+	// Error contains the reason the last attempt to deliver the message
+	// failed.
+	Error string `json:"error,omitempty"`
+
+	// Attempts contains the number of attempts made to deliver the message.
+	Attempts int `json:"attempts"`
+
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// notBefore contains when the entry is next due to be delivered.
+	notBefore time.Time
+}
+
+// OutboxOptions configures how an outbox delivers messages.
+type OutboxOptions struct {
+	// Workers contains the number of messages delivered at once. Defaults to
+	// DefaultOutboxWorkers.
+	Workers int
+
+	// MaxAttempts contains the number of attempts made to deliver a message
+	// before it is marked as failed. Defaults to DefaultOutboxMaxAttempts.
+	MaxAttempts int
+
+	// RetryDelay contains the wait before a failed delivery is retried,
+	// doubling with each attempt. Defaults to DefaultOutboxRetryDelay.
+	RetryDelay time.Duration
+
+	// Retention contains how long sent and failed entries are kept after
+	// their last update. Once pruned, an entry can no longer be looked up,
+	// and its reference no longer stops the message being queued again.
+	// Defaults to DefaultOutboxRetention.
+	Retention time.Duration
+
+	// CompactInterval contains how often Run prunes expired entries and
+	// rewrites the journal without them. Defaults to
+	// DefaultOutboxCompactInterval.
+	CompactInterval time.Duration
+}
+
+// Outbox is a durable queue of messages waiting to be sent. Messages are
+// written to a journal file before Enqueue returns, and delivered by workers
+// started with Run. Messages that hadn't been delivered when the process
+// stopped are delivered once the outbox is reopened.
+//
+// Delivery is at least once: if the process stops after a message is sent but
+// before its outcome is recorded, it will be sent again. Messages are given a
+// Reference if they don't have one, and a message with the same destination
+// and reference as one already in the outbox is not queued twice.
+type Outbox struct {
+	gateway *MobileGatewayService
+	opts    OutboxOptions
+	path    string
+
+	mu         sync.Mutex
+	file       *os.File
+	entries    map[uint64]*OutboxEntry
+	references map[string]uint64
+	nextID     uint64
+
+	// pending holds the entries waiting to be delivered, ordered by when
+	// they are due. Entries are removed while they are being delivered.
+	pending outboxQueue
+
+	// wake signals idle workers that an entry has been queued.
+	wake chan struct{}
+}
+
+// OpenOutbox opens the outbox journaled at path, creating it if it doesn't
+// exist, which delivers messages through gateway. Call Run to start
+// delivering messages.
+func OpenOutbox(path string, gateway *MobileGatewayService, opts OutboxOptions) (*Outbox, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultOutboxWorkers
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOutboxMaxAttempts
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultOutboxRetryDelay
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultOutboxRetention
+	}
+	if opts.CompactInterval <= 0 {
+		opts.CompactInterval = DefaultOutboxCompactInterval
+	}
+
+	o := &Outbox{
+		gateway:    gateway,
+		opts:       opts,
+		path:       path,
+		entries:    make(map[uint64]*OutboxEntry),
+		references: make(map[string]uint64),
+		nextID:     1,
+		wake:       make(chan struct{}, 1),
+	}
+
+	if err := o.replay(); err != nil {
+		return nil, err
+	}
+	for _, entry := range o.entries {
+		if entry.State == OutboxPending {
+			o.pending = append(o.pending, entry)
+		}
+	}
+	heap.Init(&o.pending)
+	o.prune(time.Now())
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// replay rebuilds the outbox from its journal, in which each line records the
+// latest state of an entry. A torn final line, left by a crash part way
+// through a write, is ignored.
+func (o *Outbox) replay() error {
+	f, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var malformed error
+	for line := 1; scanner.Scan(); line++ {
+		if malformed != nil {
+			return malformed
+		}
+
+		var entry OutboxEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			malformed = fmt.Errorf("outbox journal %s is corrupt at line %d: %v", o.path, line, err)
+			continue
+		}
+
+		o.entries[entry.ID] = &entry
+		if key := referenceKey(&entry.Message); key != "" {
+			o.references[key] = entry.ID
+		}
+		if entry.ID >= o.nextID {
+			o.nextID = entry.ID + 1
+		}
+	}
+
+	return scanner.Err()
+}
+
+// prune drops sent and failed entries last updated before the retention
+// period.
+func (o *Outbox) prune(now time.Time) {
+	cutoff := now.Add(-o.opts.Retention)
+	for id, entry := range o.entries {
+		if entry.State == OutboxPending || !entry.UpdatedAt.Before(cutoff) {
+			continue
+		}
+
+		delete(o.entries, id)
+		if key := referenceKey(&entry.Message); o.references[key] == id {
+			delete(o.references, key)
+		}
+	}
+}
+
+// compact rewrites the journal with only the latest state of each entry.
+func (o *Outbox) compact() error {
+	tmp, err := os.OpenFile(filepath.Join(filepath.Dir(o.path), "."+filepath.Base(o.path)+".tmp"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, entry := range o.sortedEntries() {
+		if err := writeOutboxEntry(w, entry); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if o.file != nil {
+		o.file.Close()
+	}
+	o.file = file
+
+	return nil
+}
+
+// Enqueue durably queues a copy of message to be sent, returning its entry.
+// If a message with the same destination and reference is already in the
+// outbox, its entry is returned instead.
+func (o *Outbox) Enqueue(message *Message) (OutboxEntry, error) {
+	if message == nil {
+		return OutboxEntry{}, ErrMobileGatewayMissingAttribute
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	key := referenceKey(message)
+	if id, ok := o.references[key]; ok && key != "" {
+		return *o.entries[id], nil
+	}
+
+	now := time.Now()
+	entry := &OutboxEntry{
+		ID:         o.nextID,
+		Message:    *message,
+		State:      OutboxPending,
+		EnqueuedAt: now,
+		UpdatedAt:  now,
+	}
+	if entry.Message.Reference == "" {
+		entry.Message.Reference = fmt.Sprintf("outbox-%d-%d", now.UnixNano(), entry.ID)
+	}
+
+	if err := o.write(entry); err != nil {
+		return OutboxEntry{}, err
+	}
+
+	o.nextID++
+	o.entries[entry.ID] = entry
+	heap.Push(&o.pending, entry)
+	o.references[referenceKey(&entry.Message)] = entry.ID
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return *entry, nil
+}
+
+// Entry returns the entry with the given ID.
+func (o *Outbox) Entry(id uint64) (OutboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, ok := o.entries[id]
+	if !ok {
+		return OutboxEntry{}, false
+	}
+
+	return *entry, true
+}
+
+// Entries returns every entry in the outbox, ordered by ID.
+func (o *Outbox) Entries() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries := o.sortedEntries()
+	copies := make([]OutboxEntry, len(entries))
+	for i, entry := range entries {
+		copies[i] = *entry
+	}
+
+	return copies
+}
+
+// Run delivers queued messages until ctx is done, returning the context's
+// error. While running, expired entries are pruned and the journal compacted
+// every CompactInterval. If the journal can't be written, Run stops and
+// returns the error, as outcomes could no longer be recorded.
+func (o *Outbox) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var runErr error
+	stop := func(err error) {
+		once.Do(func() {
+			runErr = err
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < o.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := o.work(ctx); err != nil {
+				stop(err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := o.maintain(ctx); err != nil {
+			stop(err)
+		}
+	}()
+	wg.Wait()
+
+	if runErr != nil {
+		return runErr
+	}
+	return ctx.Err()
+}
+
+// maintain prunes and compacts the outbox every CompactInterval until ctx is
+// done.
+func (o *Outbox) maintain(ctx context.Context) error {
+	ticker := time.NewTicker(o.opts.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			o.mu.Lock()
+			o.prune(now)
+			err := o.compact()
+			o.mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("compacting outbox journal %s: %w", o.path, err)
+			}
+		}
+	}
+}
+
+// Close closes the outbox's journal. It should only be called once Run has
+// returned.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.file.Close()
+}
+
+// work delivers due entries until ctx is done, or until an outcome can't be
+// journaled.
+func (o *Outbox) work(ctx context.Context) error {
+	for {
+		entry, wait := o.next()
+		if entry != nil {
+			if err := o.deliver(ctx, entry); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-o.wake:
+		case <-timeout:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// next claims the oldest pending entry that is due to be delivered. If there
+// isn't one, it returns how long until the next entry is due, or zero if
+// there are no pending entries.
+func (o *Outbox) next() (*OutboxEntry, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.pending.Len() == 0 {
+		return nil, 0
+	}
+	if until := o.pending[0].notBefore.Sub(time.Now()); until > 0 {
+		return nil, until
+	}
+
+	entry := heap.Pop(&o.pending).(*OutboxEntry)
+
+	// Wake another worker in case more entries are due.
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	message := *entry
+	return &message, 0
+}
+
+// deliver sends a claimed entry, and records the outcome. It returns an error
+// if the outcome can't be journaled.
+func (o *Outbox) deliver(ctx context.Context, entry *OutboxEntry) error {
+	messageID, err := o.gateway.CreateMessageContext(ctx, &entry.Message)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		// Shutting down; the entry is still pending and will be delivered
+		// when the outbox is next run.
+		heap.Push(&o.pending, o.entries[entry.ID])
+		return nil
+	}
+
+	updated := *o.entries[entry.ID]
+	updated.Attempts++
+	updated.UpdatedAt = time.Now()
+	switch {
+	case err == nil:
+		updated.State = OutboxSent
+		updated.MessageID = messageID
+		updated.Error = ""
+
+	case updated.Attempts >= o.opts.MaxAttempts || !isTransientError(err):
+		updated.State = OutboxFailed
+		updated.Error = err.Error()
+
+	default:
+		updated.Error = err.Error()
+		updated.notBefore = updated.UpdatedAt.Add(o.retryDelay(updated.Attempts))
+	}
+
+	// The outcome is kept in memory even if it can't be journaled, so the
+	// message isn't sent again by this process. The journal still records
+	// the entry as pending, so it will be delivered again when the outbox is
+	// reopened.
+	o.entries[entry.ID] = &updated
+	if updated.State == OutboxPending {
+		heap.Push(&o.pending, &updated)
+	}
+	if err := o.write(&updated); err != nil {
+		return fmt.Errorf("journaling outbox entry %d: %w", entry.ID, err)
+	}
+
+	return nil
+}
+
+// retryDelay returns the wait before the next attempt to deliver an entry.
+func (o *Outbox) retryDelay(attempts int) time.Duration {
+	delay := o.opts.RetryDelay
+	for i := 1; i < attempts && delay < maxOutboxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxOutboxRetryDelay {
+		delay = maxOutboxRetryDelay
+	}
+
+	return delay
+}
+
+// write appends an entry's state to the journal, syncing it to disk.
+func (o *Outbox) write(entry *OutboxEntry) error {
+	w := bufio.NewWriter(o.file)
+	if err := writeOutboxEntry(w, entry); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return o.file.Sync()
+}
+
+// outboxQueue orders pending entries by when they are next due, then by ID.
+type outboxQueue []*OutboxEntry
+
+func (q outboxQueue) Len() int { return len(q) }
+func (q outboxQueue) Less(i, j int) bool {
+	if !q[i].notBefore.Equal(q[j].notBefore) {
+		return q[i].notBefore.Before(q[j].notBefore)
+	}
+	return q[i].ID < q[j].ID
+}
+func (q outboxQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *outboxQueue) Push(x interface{}) {
+	*q = append(*q, x.(*OutboxEntry))
+}
+
+func (q *outboxQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// sortedEntries returns the outbox's entries ordered by ID.
+func (o *Outbox) sortedEntries() []*OutboxEntry {
+	entries := make([]*OutboxEntry, 0, len(o.entries))
+	for _, entry := range o.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+
+	return entries
+}
+
+func writeOutboxEntry(w *bufio.Writer, entry *OutboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	w.Write(data)
+	return w.WriteByte('\n')
+}
@@ -0,0 +1,308 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempOutboxPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "modica-outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "outbox.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+// runOutbox runs the outbox until every entry is sent or failed.
+func runOutbox(t *testing.T, outbox *Outbox) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		outbox.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		finished := true
+		for _, entry := range outbox.Entries() {
+			if entry.State == OutboxPending {
+				finished = false
+			}
+		}
+		if finished {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Outbox did not deliver every entry in time")
+}
+
+func TestOutbox_DeliversAfterRestart(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	path, cleanup := tempOutboxPath(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	sent := 0
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent++
+		fmt.Fprintf(w, `[%d]`, 100+sent)
+	})
+
+	// Queue a message, then stop before it is delivered.
+	outbox, err := OpenOutbox(path, client.MobileGateway, OutboxOptions{})
+	if err != nil {
+		t.Fatalf("OpenOutbox returned error: %v", err)
+	}
+	entry, err := outbox.Enqueue(&Message{Destination: "+64211234567", Content: "Hello"})
+	if err != nil {
+		t.Fatalf("Outbox.Enqueue returned error: %v", err)
+	}
+	if entry.State != OutboxPending || entry.Message.Reference == "" {
+		t.Errorf("Outbox.Enqueue returned %+v, want a pending entry with a reference", entry)
+	}
+	outbox.Close()
+
+	// Simulate a crash part way through writing to the journal.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"id":2,"mess`)
+	f.Close()
+
+	outbox, err = OpenOutbox(path, client.MobileGateway, OutboxOptions{})
+	if err != nil {
+		t.Fatalf("OpenOutbox returned error: %v", err)
+	}
+	defer outbox.Close()
+	runOutbox(t, outbox)
+
+	got, ok := outbox.Entry(entry.ID)
+	if !ok || got.State != OutboxSent || got.MessageID != 101 || got.Attempts != 1 {
+		t.Errorf("Outbox.Entry returned %+v, want a sent entry with message ID %d", got, 101)
+	}
+
+	// Queuing the same reference again doesn't send it twice.
+	again, err := outbox.Enqueue(&got.Message)
+	if err != nil || again.ID != entry.ID {
+		t.Errorf("Outbox.Enqueue returned %+v, %v, want the existing entry", again, err)
+	}
+	if sent != 1 {
+		t.Errorf("Outbox sent %d messages, want %d", sent, 1)
+	}
+}
+
+func TestOutbox_RetriesTransientFailures(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	path, cleanup := tempOutboxPath(t)
+	defer cleanup()
+
+	var mu sync.Mutex
+	attempts := 0
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `[7]`)
+	})
+
+	outbox, err := OpenOutbox(path, client.MobileGateway, OutboxOptions{Workers: 1, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("OpenOutbox returned error: %v", err)
+	}
+	defer outbox.Close()
+
+	entry, _ := outbox.Enqueue(&Message{Destination: "+64211234567", Content: "Hello"})
+	runOutbox(t, outbox)
+
+	got, _ := outbox.Entry(entry.ID)
+	if got.State != OutboxSent || got.MessageID != 7 || got.Attempts != 2 {
+		t.Errorf("Outbox.Entry returned %+v, want a sent entry after %d attempts", got, 2)
+	}
+}
+
+func TestOutbox_PermanentFailure(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	path, cleanup := tempOutboxPath(t)
+	defer cleanup()
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error-desc":"Invalid destination (+64211234567)","error":"invalid_attrib"}`)
+	})
+
+	outbox, err := OpenOutbox(path, client.MobileGateway, OutboxOptions{RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("OpenOutbox returned error: %v", err)
+	}
+	entry, _ := outbox.Enqueue(&Message{Destination: "+64211234567", Content: "Hello"})
+	runOutbox(t, outbox)
+	outbox.Close()
+
+	// The outcome survives reopening the outbox.
+	outbox, err = OpenOutbox(path, client.MobileGateway, OutboxOptions{})
+	if err != nil {
+		t.Fatalf("OpenOutbox returned error: %v", err)
+	}
+	defer outbox.Close()
+
+	got, _ := outbox.Entry(entry.ID)
+	if got.State != OutboxFailed || got.Attempts != 1 || got.Error == "" {
+		t.Errorf("Outbox.Entry returned %+v, want a failed entry after %d attempt", got, 1)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network", &url.Error{Op: "Post", URL: "https://example.com", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"send failed", &APIError{StatusCode: http.StatusBadRequest, err: ErrMobileGatewaySendFailed}, true},
+		{"too many requests", &APIError{StatusCode: http.StatusTooManyRequests}, true},
+		{"server error", &APIError{StatusCode: http.StatusBadGateway}, true},
+		{"bad request", &APIError{StatusCode: http.StatusBadRequest}, false},
+		{"not found", &APIError{StatusCode: http.StatusNotFound, err: ErrNotFound}, false},
+		{"missing message ID", ErrMobileGatewayMessageIDNotFound, false},
+		{"not allowed", fmt.Errorf("%w (+64211234567)", ErrDestinationNotAllowed), false},
+		{"no route", ErrNoRoute, false},
+		{"template", ErrTemplateNotFound, false},
+		{"nil context", errNilContext, false},
+		{"invalid number", ErrInvalidPhoneNumber, false},
+	}
+
+	for _, test := range tests {
+		if got := isTransientError(test.err); got != test.want {
+			t.Errorf("isTransientError(%s) returned %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOutbox_PrunesSettledEntries(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	path, cleanup := tempOutboxPath(t)
+	defer cleanup()
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[7]`)
+	})
+
+	opts := OutboxOptions{Retention: time.Millisecond, CompactInterval: time.Millisecond}
+	outbox, err := OpenOutbox(path, client.MobileGateway, opts)
+	if err != nil {
+		t.Fatalf("OpenOutbox returned error: %v", err)
+	}
+	defer outbox.Close()
+
+	entry, _ := outbox.Enqueue(&Message{Destination: "+64211234567", Content: "Hello"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- outbox.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := outbox.Entry(entry.ID); !ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Outbox.Run returned %v, want %v", err, context.Canceled)
+	}
+
+	if got, ok := outbox.Entry(entry.ID); ok {
+		t.Errorf("Outbox.Entry returned %+v, want the sent entry to be pruned", got)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Outbox journal contains %q, want it compacted to nothing", data)
+	}
+}
+
+func TestOutbox_RunReturnsJournalErrors(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	path, cleanup := tempOutboxPath(t)
+	defer cleanup()
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[7]`)
+	})
+
+	outbox, err := OpenOutbox(path, client.MobileGateway, OutboxOptions{})
+	if err != nil {
+		t.Fatalf("OpenOutbox returned error: %v", err)
+	}
+	entry, _ := outbox.Enqueue(&Message{Destination: "+64211234567", Content: "Hello"})
+	outbox.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := outbox.Run(ctx); err == nil || err == context.DeadlineExceeded {
+		t.Errorf("Outbox.Run returned %v, want a journal error", err)
+	}
+
+	got, _ := outbox.Entry(entry.ID)
+	if got.State != OutboxSent || got.MessageID != 7 {
+		t.Errorf("Outbox.Entry returned %+v, want the entry to be sent", got)
+	}
+}
+
+func TestOutbox_JournalIsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't enforced on windows")
+	}
+
+	client, _, _, teardown := setup()
+	defer teardown()
+	path, cleanup := tempOutboxPath(t)
+	defer cleanup()
+
+	outbox, err := OpenOutbox(path, client.MobileGateway, OutboxOptions{})
+	if err != nil {
+		t.Fatalf("OpenOutbox returned error: %v", err)
+	}
+	defer outbox.Close()
+	outbox.Enqueue(&Message{Destination: "+64211234567", Content: "Hello"})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		t.Errorf("Outbox journal has mode %v, want it readable only by its owner", mode)
+	}
+}
@@ -0,0 +1,362 @@
+package modica
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Region identifies the country a phone number is dialled from, which decides
+// how numbers without an international prefix are interpreted.
+type Region string
+
+const (
+	// RegionNZ interprets national numbers as New Zealand numbers.
+	RegionNZ Region = "NZ"
+
+	// RegionAU interprets national numbers as Australian numbers.
+	RegionAU Region = "AU"
+
+	// RegionUS interprets national numbers as North American Numbering Plan
+	// numbers.
+	RegionUS Region = "US"
+)
+
+// NumberType describes the kind of service a phone number belongs to.
+type NumberType int
+
+const (
+	// NumberTypeUnknown is returned for numbers that couldn't be classified.
+	NumberTypeUnknown NumberType = iota
+
+	// NumberTypeMobile is returned for mobile numbers.
+	NumberTypeMobile
+
+	// NumberTypeLandline is returned for fixed line numbers.
+	NumberTypeLandline
+
+	// NumberTypeLandlineOrMobile is returned where mobile and fixed line
+	// numbers share the same ranges, such as in North America.
+	NumberTypeLandlineOrMobile
+)
+
+// String returns a human readable name for the number type.
+func (t NumberType) String() string {
+	switch t {
+	case NumberTypeMobile:
+		return "mobile"
+	case NumberTypeLandline:
+		return "landline"
+	case NumberTypeLandlineOrMobile:
+		return "landline or mobile"
+	}
+
+	return "unknown"
+}
+
+const (
+	// minE164Digits and maxE164Digits bound the number of digits in a well
+	// formed E.164 number, including the country code.
+	minE164Digits = 8
+	maxE164Digits = 15
+)
+
+// numberingPlan describes how to parse and validate numbers for a country.
+type numberingPlan struct {
+	region      Region
+	countryCode string
+
+	// trunkPrefix is dialled before national numbers within the country.
+	trunkPrefix string
+
+	// internationalPrefixes are dialled before a country code when calling
+	// out of the country.
+	internationalPrefixes []string
+
+	// classify returns the type of a national significant number, or false
+	// if the number isn't possible in the plan.
+	classify func(nsn string) (NumberType, bool)
+}
+
+var numberingPlans = []numberingPlan{
+	{
+		region:                RegionNZ,
+		countryCode:           "64",
+		trunkPrefix:           "0",
+		internationalPrefixes: []string{"00"},
+		classify:              classifyNZ,
+	},
+	{
+		region:                RegionAU,
+		countryCode:           "61",
+		trunkPrefix:           "0",
+		internationalPrefixes: []string{"0011"},
+		classify:              classifyAU,
+	},
+	{
+		region:                RegionUS,
+		countryCode:           "1",
+		trunkPrefix:           "1",
+		internationalPrefixes: []string{"011"},
+		classify:              classifyNANP,
+	},
+}
+
+// classifyNZ validates New Zealand national significant numbers.
+func classifyNZ(nsn string) (NumberType, bool) {
+	switch {
+	case hasAnyPrefix(nsn, "20", "21", "22", "27", "28", "29"):
+		// Mobile numbers have 6 to 8 digits after the 2x prefix.
+		return NumberTypeMobile, len(nsn) >= 8 && len(nsn) <= 10
+
+	case hasAnyPrefix(nsn, "3", "4", "6", "7", "9"):
+		// Landlines have a single digit area code and 7 digit subscriber
+		// number, which can't start with 0 or 1.
+		return NumberTypeLandline, len(nsn) == 8 && nsn[1] >= '2'
+	}
+
+	return NumberTypeUnknown, false
+}
+
+// classifyAU validates Australian national significant numbers.
+func classifyAU(nsn string) (NumberType, bool) {
+	if len(nsn) != 9 {
+		return NumberTypeUnknown, false
+	}
+
+	switch nsn[0] {
+	case '4':
+		return NumberTypeMobile, true
+	case '2', '3', '7', '8':
+		return NumberTypeLandline, true
+	}
+
+	return NumberTypeUnknown, false
+}
+
+// classifyNANP validates North American Numbering Plan numbers, where area
+// codes and exchanges can't start with 0 or 1.
+func classifyNANP(nsn string) (NumberType, bool) {
+	if len(nsn) != 10 || nsn[0] < '2' || nsn[3] < '2' {
+		return NumberTypeUnknown, false
+	}
+
+	return NumberTypeLandlineOrMobile, true
+}
+
+// PhoneNumber contains a parsed and validated phone number.
+type PhoneNumber struct {
+	// Region contains the region the number belongs to.
+	Region Region
+
+	// CountryCode contains the international calling code, without the
+	// leading plus.
+	CountryCode string
+
+	// NationalNumber contains the national significant number, without any
+	// trunk prefix.
+	NationalNumber string
+
+	// Type contains the kind of service the number belongs to.
+	Type NumberType
+}
+
+// ParsePhoneNumber parses a phone number written in either international or
+// national format, such as "+64 21 123 4567", "021 123 4567" or
+// "(04) 555-1234". National numbers are interpreted as belonging to
+// defaultRegion. ErrInvalidPhoneNumber is returned if the number isn't
+// possible.
+//
+// Only New Zealand, Australian and North American numbers are validated
+// against their numbering plan. International numbers in other countries are
+// accepted if they are well formed E.164 numbers, and are returned with an
+// empty Region and CountryCode, and the digits after the plus as their
+// NationalNumber.
+func ParsePhoneNumber(raw string, defaultRegion Region) (PhoneNumber, error) {
+	digits, international, ok := stripPhoneNumber(raw)
+	if !ok || digits == "" {
+		return PhoneNumber{}, ErrInvalidPhoneNumber
+	}
+
+	plan, hasDefault := planForRegion(defaultRegion)
+	if !international && hasDefault {
+		for _, prefix := range plan.internationalPrefixes {
+			if strings.HasPrefix(digits, prefix) {
+				digits = digits[len(prefix):]
+				international = true
+				break
+			}
+		}
+	}
+
+	var nsn string
+	if international {
+		found := false
+		for _, p := range numberingPlans {
+			if strings.HasPrefix(digits, p.countryCode) {
+				plan, nsn, found = p, digits[len(p.countryCode):], true
+				break
+			}
+		}
+		if !found {
+			// Numbers in countries without a known numbering plan are
+			// passed through unchanged, as long as they're well formed.
+			if len(digits) < minE164Digits || len(digits) > maxE164Digits || digits[0] == '0' {
+				return PhoneNumber{}, ErrInvalidPhoneNumber
+			}
+			return PhoneNumber{NationalNumber: digits, Type: NumberTypeUnknown}, nil
+		}
+
+		// Numbers are often written with the trunk prefix kept after the
+		// country code, e.g. +64 (0)21 123 4567.
+		if plan.trunkPrefix == "0" && strings.HasPrefix(nsn, plan.trunkPrefix) {
+			nsn = nsn[len(plan.trunkPrefix):]
+		}
+	} else {
+		if !hasDefault {
+			return PhoneNumber{}, ErrInvalidPhoneNumber
+		}
+
+		nsn = digits
+		if plan.region == RegionUS {
+			// The NANP trunk prefix is optional.
+			if len(nsn) == 11 && strings.HasPrefix(nsn, plan.trunkPrefix) {
+				nsn = nsn[1:]
+			}
+		} else {
+			if !strings.HasPrefix(nsn, plan.trunkPrefix) {
+				return PhoneNumber{}, ErrInvalidPhoneNumber
+			}
+			nsn = nsn[len(plan.trunkPrefix):]
+		}
+	}
+
+	numberType, ok := plan.classify(nsn)
+	if !ok {
+		return PhoneNumber{}, ErrInvalidPhoneNumber
+	}
+
+	return PhoneNumber{
+		Region:         plan.region,
+		CountryCode:    plan.countryCode,
+		NationalNumber: nsn,
+		Type:           numberType,
+	}, nil
+}
+
+// NormalizePhoneNumber parses a phone number and returns it in E.164 format.
+func NormalizePhoneNumber(raw string, defaultRegion Region) (string, error) {
+	number, err := ParsePhoneNumber(raw, defaultRegion)
+	if err != nil {
+		return "", err
+	}
+
+	return number.E164(), nil
+}
+
+// E164 returns the number in E.164 format, e.g. +64211234567, as required by
+// the Mobile Gateway API.
+func (p PhoneNumber) E164() string {
+	return "+" + p.CountryCode + p.NationalNumber
+}
+
+// String returns the number in E.164 format.
+func (p PhoneNumber) String() string {
+	return p.E164()
+}
+
+// IsMobile reports whether the number could belong to a mobile phone.
+func (p PhoneNumber) IsMobile() bool {
+	return p.Type == NumberTypeMobile || p.Type == NumberTypeLandlineOrMobile
+}
+
+// SetDefaultRegion enables normalising message destinations into E.164
+// format before they are sent, interpreting national numbers as belonging to
+// region. Destinations that aren't possible numbers are rejected locally with
+// ErrInvalidPhoneNumber instead of being sent. An empty region disables
+// normalisation.
+func (c *Client) SetDefaultRegion(region Region) {
+	c.defaultRegion = region
+}
+
+// WithDefaultRegion normalises message destinations into E.164 format,
+// interpreting national numbers as belonging to region. See
+// Client.SetDefaultRegion.
+func WithDefaultRegion(region Region) ClientOption {
+	return func(cfg *clientConfig) error {
+		if _, ok := planForRegion(region); !ok && region != "" {
+			return fmt.Errorf("DefaultRegion %q is not a supported region", region)
+		}
+
+		cfg.defaultRegion = region
+		return nil
+	}
+}
+
+// phoneNumberKey returns number in a canonical form, so the same number
+// written in different formats can be matched. Numbers that can be parsed are
+// returned in E.164 format, interpreting national numbers as belonging to
+// region. Otherwise the number is returned with everything but its digits and
+// any leading plus removed.
+func phoneNumberKey(number string, region Region) string {
+	if normalized, err := NormalizePhoneNumber(number, region); err == nil {
+		return normalized
+	}
+
+	number = strings.TrimSpace(number)
+	var b strings.Builder
+	if strings.HasPrefix(number, "+") {
+		b.WriteByte('+')
+	}
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// stripPhoneNumber removes formatting characters from a phone number,
+// returning the remaining digits and whether it was written with a leading
+// plus. ok is false if the number contains characters that can't appear in a
+// phone number.
+func stripPhoneNumber(raw string) (digits string, international bool, ok bool) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "+") {
+		international = true
+		raw = raw[1:]
+	}
+
+	b := make([]byte, 0, len(raw))
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			b = append(b, byte(r))
+		case r == ' ', r == '-', r == '.', r == '(', r == ')':
+			// Formatting characters are ignored.
+		default:
+			return "", false, false
+		}
+	}
+
+	return string(b), international, true
+}
+
+func planForRegion(region Region) (numberingPlan, bool) {
+	for _, plan := range numberingPlans {
+		if plan.region == Region(strings.ToUpper(string(region))) {
+			return plan, true
+		}
+	}
+
+	return numberingPlan{}, false
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,121 @@
+package modica
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParsePhoneNumber(t *testing.T) {
+	tests := []struct {
+		raw    string
+		region Region
+		want   string
+		typ    NumberType
+	}{
+		{raw: "021 123 4567", region: RegionNZ, want: "+64211234567", typ: NumberTypeMobile},
+		{raw: "(04) 555-1234", region: RegionNZ, want: "+6445551234", typ: NumberTypeLandline},
+		{raw: "+64 21 123 4567", region: RegionAU, want: "+64211234567", typ: NumberTypeMobile},
+		{raw: "+64 (0)21 123 4567", region: RegionNZ, want: "+64211234567", typ: NumberTypeMobile},
+		{raw: "0064211234567", region: RegionNZ, want: "+64211234567", typ: NumberTypeMobile},
+		{raw: "0414 123 456", region: RegionAU, want: "+61414123456", typ: NumberTypeMobile},
+		{raw: "(02) 9876 5432", region: RegionAU, want: "+61298765432", typ: NumberTypeLandline},
+		{raw: "(812) 345-6789", region: RegionUS, want: "+18123456789", typ: NumberTypeLandlineOrMobile},
+		{raw: "1-812-345-6789", region: RegionUS, want: "+18123456789", typ: NumberTypeLandlineOrMobile},
+		{raw: "+18123456789", region: "", want: "+18123456789", typ: NumberTypeLandlineOrMobile},
+		{raw: "021 123 4567", region: "nz", want: "+64211234567", typ: NumberTypeMobile},
+		{raw: "+44 20 7946 0958", region: RegionNZ, want: "+442079460958", typ: NumberTypeUnknown},
+		{raw: "+447700900123", region: "", want: "+447700900123", typ: NumberTypeUnknown},
+	}
+	for _, test := range tests {
+		got, err := ParsePhoneNumber(test.raw, test.region)
+		if err != nil {
+			t.Errorf("ParsePhoneNumber(%q, %q) returned error: %v", test.raw, test.region, err)
+			continue
+		}
+		if got.E164() != test.want {
+			t.Errorf("ParsePhoneNumber(%q, %q) returned %s, want %s", test.raw, test.region, got, test.want)
+		}
+		if got.Type != test.typ {
+			t.Errorf("ParsePhoneNumber(%q, %q) returned type %s, want %s", test.raw, test.region, got.Type, test.typ)
+		}
+	}
+}
+
+func TestParsePhoneNumber_Invalid(t *testing.T) {
+	tests := []struct {
+		raw    string
+		region Region
+	}{
+		{raw: "", region: RegionNZ},
+		{raw: "LOL This Isn't a destination number", region: RegionNZ},
+		{raw: "021 123", region: RegionNZ},
+		{raw: "(04) 155-1234", region: RegionNZ},
+		{raw: "0123456789", region: RegionNZ},
+		{raw: "021 123 4567", region: ""},
+		{raw: "+0123456789", region: RegionNZ},
+		{raw: "+44 207", region: RegionNZ},
+		{raw: "+44 2079 4609 5812 34", region: RegionNZ},
+		{raw: "0514 123 456", region: RegionAU},
+		{raw: "(123) 456-7890", region: RegionUS},
+	}
+	for _, test := range tests {
+		if got, err := ParsePhoneNumber(test.raw, test.region); err != ErrInvalidPhoneNumber {
+			t.Errorf("ParsePhoneNumber(%q, %q) returned %v, %v, want %v", test.raw, test.region, got, err, ErrInvalidPhoneNumber)
+		}
+	}
+}
+
+func TestMobileGatewayService_CreateMessage_DefaultRegion(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetDefaultRegion(RegionNZ)
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":"+64211234567","content":"Hello"}`+"\n")
+		fmt.Fprint(w, `[123]`)
+	})
+
+	payload := &Message{Destination: "021 123 4567", Content: "Hello"}
+	if _, err := client.MobileGateway.CreateMessage(payload); err != nil {
+		t.Fatalf("MobileGateway.CreateMessage returned error: %v", err)
+	}
+	if payload.Destination != "021 123 4567" {
+		t.Errorf("MobileGateway.CreateMessage modified the caller's message destination to %q", payload.Destination)
+	}
+
+	payload = &Message{Destination: "LOL", Content: "Hello"}
+	if _, err := client.MobileGateway.CreateMessage(payload); err != ErrInvalidPhoneNumber {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrInvalidPhoneNumber)
+	}
+}
+
+func TestMobileGatewayService_CreateBroadcastMessage_DefaultRegion(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+	client.SetDefaultRegion(RegionNZ)
+
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":["+64211234567","+6445551234"],"content":"Hello"}`+"\n")
+		fmt.Fprint(w, `[{"status":"success","message":null,"destination":"+64211234567","id":123},{"status":"success","message":null,"destination":"+6445551234","id":124}]`)
+	})
+
+	payload := &BroadcastMessage{
+		Destinations: []string{"021 123 4567", "X", "(04) 555-1234"},
+		Message:      Message{Content: "Hello"},
+	}
+	got, err := client.MobileGateway.CreateBroadcastMessage(payload)
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+
+	want := []BroadcastResponse{
+		{Status: "success", Destination: "+64211234567", ID: 123},
+		{Status: "success", Destination: "+6445551234", ID: 124},
+		{Status: "failure", Message: "Invalid destination (X)", Destination: "X"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,435 @@
+package modica
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// defaultMessageOwnersSize limits the number of sent message IDs a router
+	// remembers the client of.
+	defaultMessageOwnersSize = 10000
+)
+
+// MobileGateway contains the operations of the Mobile Gateway API. It is
+// implemented by MobileGatewayService for a single account, and by Router for
+// several.
+type MobileGateway interface {
+	AddPreSendHook(hook PreSendHook)
+	CreateMessage(newMessage *Message) (messageID int, err error)
+	CreateMessageContext(ctx context.Context, newMessage *Message) (messageID int, err error)
+	GetMessage(messageID int) (message *Message, err error)
+	GetMessageContext(ctx context.Context, messageID int) (message *Message, err error)
+	CreateBroadcastMessage(newMessage *BroadcastMessage) (broadcastResponses []BroadcastResponse, err error)
+	CreateBroadcastMessageContext(ctx context.Context, newMessage *BroadcastMessage) (broadcastResponses []BroadcastResponse, err error)
+	CreateBroadcastMessageBatched(ctx context.Context, newMessage *BroadcastMessage, opts BroadcastBatchOptions) (*BroadcastBatchResult, error)
+	WaitForDelivery(ctx context.Context, messageIDs []int, opts DeliveryOptions) ([]DeliveryResult, error)
+	CreateTemplateMessage(tm TemplateMessage) (messageID int, err error)
+	CreateTemplateMessageContext(ctx context.Context, tm TemplateMessage) (messageID int, err error)
+}
+
+var (
+	_ MobileGateway = MobileGatewayService{}
+	_ MobileGateway = (*Router)(nil)
+)
+
+// RouteRule selects the client used to send messages. Every field that is set
+// must match for the rule to apply.
+type RouteRule struct {
+	// Region matches messages whose destination belongs to the region.
+	// Destinations in national format are interpreted in the default region
+	// of the rule's client, or in the rule's region if the client has none.
+	Region Region
+
+	// Source matches messages sent from the source short code or number.
+	Source string
+
+	// Class matches messages sent with the class.
+	Class string
+
+	// Tenant matches messages sent with a context carrying the tenant ID,
+	// attached with WithTenant.
+	Tenant string
+
+	// Client contains the client used to send matching messages.
+	Client *Client
+}
+
+// matches reports whether the rule applies to a message sent to destination.
+func (r RouteRule) matches(tenant string, message *Message, destination string) bool {
+	if r.Tenant != "" && r.Tenant != tenant {
+		return false
+	}
+	if r.Source != "" && r.Source != message.Source {
+		return false
+	}
+	if r.Class != "" && r.Class != message.Class {
+		return false
+	}
+	if r.Region != "" {
+		// National numbers are interpreted in the client's default region
+		// if it has one, and otherwise in the rule's.
+		region := r.Client.defaultRegion
+		if region == "" {
+			region = r.Region
+		}
+
+		number, err := ParsePhoneNumber(destination, region)
+		if err != nil || !strings.EqualFold(string(number.Region), string(r.Region)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Router sends messages through one of several clients, such as clients for
+// different Modica applications or billing accounts, chosen by routing rules.
+// It has the same methods as MobileGatewayService, so callers can use either
+// through the MobileGateway interface.
+type Router struct {
+	rules    []RouteRule
+	fallback *Client
+
+	// Clients recently sent messages were created through.
+	owners *messageOwners
+}
+
+// NewRouter returns a router that sends each message through the client of the
+// first rule matching it, or through fallback if none match. If fallback is
+// nil, messages no rule matches are rejected with ErrNoRoute.
+func NewRouter(fallback *Client, rules ...RouteRule) (*Router, error) {
+	for i, rule := range rules {
+		if rule.Client == nil {
+			return nil, fmt.Errorf("RouteRule %d must have a Client", i)
+		}
+	}
+
+	return &Router{
+		rules:    rules,
+		fallback: fallback,
+		owners:   newMessageOwners(defaultMessageOwnersSize),
+	}, nil
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying tenant, for routing messages sent
+// with it.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant ID carried by ctx, if any.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// Route returns the client a message to destination is sent through.
+func (r *Router) Route(ctx context.Context, message *Message, destination string) (*Client, error) {
+	if ctx == nil {
+		return nil, errNilContext
+	}
+	if message == nil {
+		message = &Message{}
+	}
+
+	tenant, _ := TenantFromContext(ctx)
+	for _, rule := range r.rules {
+		if rule.matches(tenant, message, destination) {
+			return rule.Client, nil
+		}
+	}
+
+	if r.fallback == nil {
+		return nil, fmt.Errorf("%w (%s)", ErrNoRoute, destination)
+	}
+
+	return r.fallback, nil
+}
+
+// clients returns each distinct client the router sends through.
+func (r *Router) clients() []*Client {
+	var clients []*Client
+	seen := make(map[*Client]bool)
+	for _, rule := range r.rules {
+		if !seen[rule.Client] {
+			seen[rule.Client] = true
+			clients = append(clients, rule.Client)
+		}
+	}
+	if r.fallback != nil && !seen[r.fallback] {
+		clients = append(clients, r.fallback)
+	}
+
+	return clients
+}
+
+// AddPreSendHook registers a hook with every client the router sends through.
+func (r *Router) AddPreSendHook(hook PreSendHook) {
+	for _, client := range r.clients() {
+		client.MobileGateway.AddPreSendHook(hook)
+	}
+}
+
+// CreateMessage sends an (outbound) message to a single destination.
+func (r *Router) CreateMessage(newMessage *Message) (messageID int, err error) {
+	return r.CreateMessageContext(context.Background(), newMessage)
+}
+
+// CreateMessageContext sends an (outbound) message to a single destination,
+// through the client routed to by its destination, sender and the tenant
+// carried by ctx.
+func (r *Router) CreateMessageContext(ctx context.Context, newMessage *Message) (messageID int, err error) {
+	var destination string
+	if newMessage != nil {
+		destination = newMessage.Destination
+	}
+
+	client, err := r.Route(ctx, newMessage, destination)
+	if err != nil {
+		return 0, err
+	}
+
+	messageID, err = client.MobileGateway.CreateMessageContext(ctx, newMessage)
+	if err == nil {
+		r.owners.add(messageID, client)
+	}
+	return messageID, err
+}
+
+// GetMessage retrieves a message.
+func (r *Router) GetMessage(messageID int) (message *Message, err error) {
+	return r.GetMessageContext(context.Background(), messageID)
+}
+
+// GetMessageContext retrieves a message. As message IDs belong to an account,
+// and different accounts may use the same ID, the request is made through the
+// client the message was sent through, if it was recently sent by the router.
+// Otherwise it is made through the client the tenant carried by ctx routes
+// to, or the router's only client. If none of these apply, ErrNoRoute is
+// returned rather than guessing which account the message belongs to.
+func (r *Router) GetMessageContext(ctx context.Context, messageID int) (message *Message, err error) {
+	if ctx == nil {
+		return nil, errNilContext
+	}
+
+	client, err := r.routeMessageID(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.MobileGateway.GetMessageContext(ctx, messageID)
+}
+
+// routeMessageID returns the client that owns messageID, as described by
+// GetMessageContext.
+func (r *Router) routeMessageID(ctx context.Context, messageID int) (*Client, error) {
+	if client, ok := r.owners.get(messageID); ok {
+		return client, nil
+	}
+	if _, ok := TenantFromContext(ctx); ok {
+		return r.Route(ctx, nil, "")
+	}
+	if clients := r.clients(); len(clients) == 1 {
+		return clients[0], nil
+	}
+
+	return nil, fmt.Errorf("%w (message %d was not sent by this router; attach a tenant with WithTenant)", ErrNoRoute, messageID)
+}
+
+// CreateBroadcastMessage sends an (outbound) message to multiple destinations.
+func (r *Router) CreateBroadcastMessage(newMessage *BroadcastMessage) (broadcastResponses []BroadcastResponse, err error) {
+	return r.CreateBroadcastMessageContext(context.Background(), newMessage)
+}
+
+// CreateBroadcastMessageContext sends an (outbound) message to multiple
+// destinations. Destinations routed to different clients are sent as separate
+// broadcasts, one after another, and their responses merged. If a broadcast
+// fails, the responses of those already sent are returned with the error.
+func (r *Router) CreateBroadcastMessageContext(ctx context.Context, newMessage *BroadcastMessage) (broadcastResponses []BroadcastResponse, err error) {
+	groups, err := r.routeBroadcast(ctx, newMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		responses, err := group.client.MobileGateway.CreateBroadcastMessageContext(ctx, group.broadcast)
+		r.addBroadcastOwners(responses, group.client)
+		broadcastResponses = append(broadcastResponses, responses...)
+		if err != nil {
+			return broadcastResponses, err
+		}
+	}
+
+	return broadcastResponses, nil
+}
+
+// CreateBroadcastMessageBatched sends an (outbound) message to any number of
+// destinations, splitting them by the client they are routed to, and then
+// into batches as MobileGatewayService.CreateBroadcastMessageBatched does.
+func (r *Router) CreateBroadcastMessageBatched(ctx context.Context, newMessage *BroadcastMessage, opts BroadcastBatchOptions) (*BroadcastBatchResult, error) {
+	groups, err := r.routeBroadcast(ctx, newMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &BroadcastBatchResult{}
+	for _, group := range groups {
+		result, err := group.client.MobileGateway.CreateBroadcastMessageBatched(ctx, group.broadcast, opts)
+		if result != nil {
+			r.addBroadcastOwners(result.Responses, group.client)
+			merged.Responses = append(merged.Responses, result.Responses...)
+			merged.Failures = append(merged.Failures, result.Failures...)
+		}
+		if err != nil && !errors.Is(err, ErrBroadcastIncomplete) {
+			return merged, err
+		}
+	}
+
+	if len(merged.Failures) > 0 {
+		return merged, ErrBroadcastIncomplete
+	}
+
+	return merged, nil
+}
+
+// addBroadcastOwners remembers the client that created each message in a
+// broadcast.
+func (r *Router) addBroadcastOwners(responses []BroadcastResponse, client *Client) {
+	for _, messageID := range BroadcastMessageIDs(responses) {
+		r.owners.add(messageID, client)
+	}
+}
+
+// routedBroadcast contains the destinations of a broadcast routed to a client.
+type routedBroadcast struct {
+	client    *Client
+	broadcast *BroadcastMessage
+}
+
+// routeBroadcast splits a broadcast into a broadcast for each client its
+// destinations are routed to, in the order the clients are first routed to.
+func (r *Router) routeBroadcast(ctx context.Context, broadcast *BroadcastMessage) ([]routedBroadcast, error) {
+	if broadcast == nil {
+		client, err := r.Route(ctx, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		return []routedBroadcast{{client: client}}, nil
+	}
+
+	var groups []routedBroadcast
+	index := make(map[*Client]int)
+	for _, destination := range broadcast.Destinations {
+		client, err := r.Route(ctx, &broadcast.Message, destination)
+		if err != nil {
+			return nil, err
+		}
+
+		i, ok := index[client]
+		if !ok {
+			group := *broadcast
+			group.Destinations = nil
+			i = len(groups)
+			index[client] = i
+			groups = append(groups, routedBroadcast{client: client, broadcast: &group})
+		}
+		groups[i].broadcast.Destinations = append(groups[i].broadcast.Destinations, destination)
+	}
+
+	if len(groups) == 0 {
+		client, err := r.Route(ctx, &broadcast.Message, "")
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, routedBroadcast{client: client, broadcast: broadcast})
+	}
+
+	return groups, nil
+}
+
+// WaitForDelivery polls the status of each message until it reaches a final
+// state, finding each message's client as GetMessageContext does. See
+// MobileGatewayService.WaitForDelivery.
+func (r *Router) WaitForDelivery(ctx context.Context, messageIDs []int, opts DeliveryOptions) ([]DeliveryResult, error) {
+	return waitForDelivery(ctx, r.GetMessageContext, messageIDs, opts)
+}
+
+// CreateTemplateMessage renders a templated message and sends it to a single
+// destination.
+func (r *Router) CreateTemplateMessage(tm TemplateMessage) (messageID int, err error) {
+	return r.CreateTemplateMessageContext(context.Background(), tm)
+}
+
+// CreateTemplateMessageContext renders a templated message with the template
+// registry of the client it is routed to, and sends it through that client.
+func (r *Router) CreateTemplateMessageContext(ctx context.Context, tm TemplateMessage) (messageID int, err error) {
+	client, err := r.Route(ctx, &tm.Message, tm.Message.Destination)
+	if err != nil {
+		return 0, err
+	}
+
+	messageID, err = client.MobileGateway.CreateTemplateMessageContext(ctx, tm)
+	if err == nil {
+		r.owners.add(messageID, client)
+	}
+	return messageID, err
+}
+
+// messageOwners remembers the client that created each recently sent message,
+// evicting the oldest entries once full.
+type messageOwners struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[int]*list.Element
+}
+
+type messageOwner struct {
+	messageID int
+	client    *Client
+}
+
+func newMessageOwners(size int) *messageOwners {
+	return &messageOwners{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[int]*list.Element),
+	}
+}
+
+func (o *messageOwners) get(messageID int) (*Client, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	elem, ok := o.entries[messageID]
+	if !ok {
+		return nil, false
+	}
+	o.order.MoveToFront(elem)
+	return elem.Value.(*messageOwner).client, true
+}
+
+func (o *messageOwners) add(messageID int, client *Client) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if elem, ok := o.entries[messageID]; ok {
+		elem.Value.(*messageOwner).client = client
+		o.order.MoveToFront(elem)
+		return
+	}
+
+	o.entries[messageID] = o.order.PushFront(&messageOwner{messageID: messageID, client: client})
+	for o.order.Len() > o.size {
+		oldest := o.order.Back()
+		o.order.Remove(oldest)
+		delete(o.entries, oldest.Value.(*messageOwner).messageID)
+	}
+}
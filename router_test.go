@@ -0,0 +1,150 @@
+package modica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRouter_Route(t *testing.T) {
+	school := NewClient(clientID, clientSecret, nil)
+	australia := NewClient(clientID, clientSecret, nil)
+	billing := NewClient(clientID, clientSecret, nil)
+	fallback := NewClient(clientID, clientSecret, nil)
+
+	router, err := NewRouter(fallback,
+		RouteRule{Tenant: "school-1", Client: school},
+		RouteRule{Region: "au", Client: australia},
+		RouteRule{Source: "2000", Class: "mt_bulk", Client: billing},
+	)
+	if err != nil {
+		t.Fatalf("NewRouter returned error: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		message *Message
+		want    *Client
+	}{
+		{name: "tenant", ctx: WithTenant(context.Background(), "school-1"), message: &Message{Destination: "+61412345678"}, want: school},
+		{name: "region", ctx: context.Background(), message: &Message{Destination: "+61412345678"}, want: australia},
+		{name: "national region", ctx: context.Background(), message: &Message{Destination: "0412 345 678"}, want: australia},
+		{name: "sender", ctx: context.Background(), message: &Message{Destination: "+64211234567", Source: "2000", Class: "mt_bulk"}, want: billing},
+		{name: "partial sender", ctx: context.Background(), message: &Message{Destination: "+64211234567", Source: "2000"}, want: fallback},
+		{name: "other tenant", ctx: WithTenant(context.Background(), "school-2"), message: &Message{Destination: "+64211234567"}, want: fallback},
+	}
+	for _, test := range tests {
+		got, err := router.Route(test.ctx, test.message, test.message.Destination)
+		if err != nil || got != test.want {
+			t.Errorf("%s: Router.Route returned %p, %v, want %p", test.name, got, err, test.want)
+		}
+	}
+
+	router, _ = NewRouter(nil, RouteRule{Tenant: "school-1", Client: school})
+	if _, err := router.CreateMessage(&Message{Destination: "+64211234567"}); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("Router.CreateMessage returned %+v, want %+v", err, ErrNoRoute)
+	}
+
+	if _, err := NewRouter(fallback, RouteRule{Tenant: "school-1"}); err == nil {
+		t.Error("NewRouter should have rejected a rule without a client")
+	}
+}
+
+func TestRouter_CreateBroadcastMessage(t *testing.T) {
+	nzClient, nzMux, _, nzTeardown := setup()
+	defer nzTeardown()
+	auClient, auMux, _, auTeardown := setup()
+	defer auTeardown()
+
+	nzMux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":["+64211111111","+64212222222"],"content":"Hello"}`+"\n")
+		fmt.Fprint(w, `[{"status":"success","message":null,"destination":"+64211111111","id":1},{"status":"success","message":null,"destination":"+64212222222","id":2}]`)
+	})
+	auMux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		testBody(t, r, `{"destination":["+61412345678"],"content":"Hello"}`+"\n")
+		fmt.Fprint(w, `[{"status":"success","message":null,"destination":"+61412345678","id":3}]`)
+	})
+
+	router, err := NewRouter(nzClient, RouteRule{Region: RegionAU, Client: auClient})
+	if err != nil {
+		t.Fatalf("NewRouter returned error: %v", err)
+	}
+
+	var gateway MobileGateway = router
+	got, err := gateway.CreateBroadcastMessage(&BroadcastMessage{
+		Destinations: []string{"+64211111111", "+61412345678", "+64212222222"},
+		Message:      Message{Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatalf("Router.CreateBroadcastMessage returned error: %v", err)
+	}
+
+	want := []BroadcastResponse{
+		{Status: "success", Destination: "+64211111111", ID: 1},
+		{Status: "success", Destination: "+64212222222", ID: 2},
+		{Status: "success", Destination: "+61412345678", ID: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Router.CreateBroadcastMessage returned %+v, want %+v", got, want)
+	}
+}
+
+func TestRouter_GetMessage(t *testing.T) {
+	fallback, fallbackMux, _, fallbackTeardown := setup()
+	defer fallbackTeardown()
+	billing, billingMux, _, billingTeardown := setup()
+	defer billingTeardown()
+
+	fallbackMux.HandleFunc("/messages/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error-desc":"Not Found","error":"not_found"}`)
+	})
+	billingMux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[42]`)
+	})
+	billingGets := 0
+	billingMux.HandleFunc("/messages/42", func(w http.ResponseWriter, r *http.Request) {
+		billingGets++
+		fmt.Fprint(w, `{"id":42,"destination":"+64211234567","status":"sent"}`)
+	})
+
+	router, err := NewRouter(fallback, RouteRule{Source: "2000", Client: billing})
+	if err != nil {
+		t.Fatalf("NewRouter returned error: %v", err)
+	}
+
+	messageID, err := router.CreateMessage(&Message{Destination: "+64211234567", Source: "2000", Content: "Hello"})
+	if err != nil {
+		t.Fatalf("Router.CreateMessage returned error: %v", err)
+	}
+	message, err := router.GetMessage(messageID)
+	if err != nil || message.Status != MessageStatusSent {
+		t.Errorf("Router.GetMessage returned %+v, %v, want a sent message", message, err)
+	}
+
+	// A router that didn't send the message doesn't guess which account it
+	// belongs to, as accounts may use the same IDs.
+	router, _ = NewRouter(fallback,
+		RouteRule{Source: "2000", Client: billing},
+		RouteRule{Tenant: "billing", Client: billing},
+	)
+	if _, err := router.GetMessage(42); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("Router.GetMessage returned %+v, want %+v", err, ErrNoRoute)
+	}
+
+	// The tenant carried by the context picks the account instead.
+	message, err = router.GetMessageContext(WithTenant(context.Background(), "billing"), 42)
+	if err != nil || message.ID != 42 {
+		t.Errorf("Router.GetMessageContext returned %+v, %v, want message %d", message, err, 42)
+	}
+	if billingGets != 2 {
+		t.Errorf("Router.GetMessage made %d requests to the sending account, want %d", billingGets, 2)
+	}
+	if _, err := router.GetMessageContext(WithTenant(context.Background(), "school"), 42); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Router.GetMessageContext returned %+v, want %+v", err, ErrNotFound)
+	}
+}
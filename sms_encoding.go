@@ -0,0 +1,166 @@
+package modica
+
+import (
+	"context"
+	"unicode/utf16"
+)
+
+// Encoding describes the character encoding an SMS is sent with.
+type Encoding int
+
+const (
+	// EncodingGSM7 is the GSM 03.38 7-bit default alphabet, allowing 160
+	// characters in a single SMS.
+	EncodingGSM7 Encoding = iota
+
+	// EncodingUCS2 is the 16-bit UCS-2 encoding, used when content contains
+	// characters outside the GSM alphabet, allowing 70 characters in a single
+	// SMS.
+	EncodingUCS2
+)
+
+// String returns the name of the encoding.
+func (e Encoding) String() string {
+	if e == EncodingUCS2 {
+		return "UCS-2"
+	}
+
+	return "GSM-7"
+}
+
+const (
+	// gsm7SingleLimit and gsm7MultiLimit contain the septets available in a
+	// single SMS, and in each part of a concatenated SMS.
+	gsm7SingleLimit = 160
+	gsm7MultiLimit  = 153
+
+	// ucs2SingleLimit and ucs2MultiLimit contain the UTF-16 code units
+	// available in a single SMS, and in each part of a concatenated SMS.
+	ucs2SingleLimit = 70
+	ucs2MultiLimit  = 67
+)
+
+// gsm7Basic contains the characters in the GSM 03.38 default alphabet.
+var gsm7Basic = makeRuneSet("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+// gsm7Extension contains the characters in the GSM 03.38 extension table,
+// which each take two septets to send.
+var gsm7Extension = makeRuneSet("\f^{}\\[~]|€")
+
+func makeRuneSet(chars string) map[rune]bool {
+	set := make(map[rune]bool)
+	for _, r := range chars {
+		set[r] = true
+	}
+	return set
+}
+
+// ContentAnalysis reports how message content will be encoded and billed.
+type ContentAnalysis struct {
+	// Encoding contains the encoding required to send the content.
+	Encoding Encoding
+
+	// Characters contains the number of characters in the content.
+	Characters int
+
+	// Units contains the number of encoded units the content takes, being
+	// septets for GSM-7, where extension characters take two, or UTF-16 code
+	// units for UCS-2.
+	Units int
+
+	// Segments contains the number of SMS parts the content is sent as.
+	Segments int
+
+	// UCS2Characters contains each distinct character that forced the
+	// content to be sent as UCS-2, in the order they first appear.
+	UCS2Characters []rune
+}
+
+// AnalyzeContent works out the encoding and number of SMS segments required to
+// send content.
+func AnalyzeContent(content string) ContentAnalysis {
+	analysis := ContentAnalysis{
+		Encoding: EncodingGSM7,
+	}
+
+	seen := make(map[rune]bool)
+	for _, r := range content {
+		analysis.Characters++
+		if !gsm7Basic[r] && !gsm7Extension[r] && !seen[r] {
+			seen[r] = true
+			analysis.Encoding = EncodingUCS2
+			analysis.UCS2Characters = append(analysis.UCS2Characters, r)
+		}
+	}
+
+	widths := make([]int, 0, analysis.Characters)
+	for _, r := range content {
+		width := runeWidth(r, analysis.Encoding)
+		widths = append(widths, width)
+		analysis.Units += width
+	}
+
+	single, multi := gsm7SingleLimit, gsm7MultiLimit
+	if analysis.Encoding == EncodingUCS2 {
+		single, multi = ucs2SingleLimit, ucs2MultiLimit
+	}
+	analysis.Segments = countSegments(widths, analysis.Units, single, multi)
+
+	return analysis
+}
+
+// runeWidth returns how many units a character takes in an encoding.
+func runeWidth(r rune, encoding Encoding) int {
+	if encoding == EncodingUCS2 {
+		return len(utf16.Encode([]rune{r}))
+	}
+	if gsm7Extension[r] {
+		return 2
+	}
+
+	return 1
+}
+
+// countSegments splits characters of the given widths into SMS parts. A
+// character is never split across parts, so extension characters and
+// surrogate pairs at the end of a part push the part count up.
+func countSegments(widths []int, units int, single int, multi int) int {
+	if units == 0 {
+		return 0
+	}
+	if units <= single {
+		return 1
+	}
+
+	segments, used := 1, 0
+	for _, width := range widths {
+		if used+width > multi {
+			segments++
+			used = 0
+		}
+		used += width
+	}
+
+	return segments
+}
+
+// SegmentBudgetHook returns a pre-send hook that enforces a maximum number of
+// SMS segments per message. If warn is nil, messages over budget are refused
+// with ErrSegmentBudgetExceeded. Otherwise, warn is called with the message
+// and its analysis, and the message is sent anyway.
+func SegmentBudgetHook(maxSegments int, warn func(message *Message, analysis ContentAnalysis)) PreSendHook {
+	return func(ctx context.Context, message *Message) error {
+		analysis := AnalyzeContent(message.Content)
+		if analysis.Segments <= maxSegments {
+			return nil
+		}
+
+		if warn == nil {
+			return ErrSegmentBudgetExceeded
+		}
+
+		warn(message, analysis)
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package modica
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    ContentAnalysis
+	}{
+		{
+			name:    "empty",
+			content: "",
+			want:    ContentAnalysis{Encoding: EncodingGSM7},
+		},
+		{
+			name:    "gsm single",
+			content: "Hello, test message!",
+			want:    ContentAnalysis{Encoding: EncodingGSM7, Characters: 20, Units: 20, Segments: 1},
+		},
+		{
+			name:    "gsm full single",
+			content: strings.Repeat("a", 160),
+			want:    ContentAnalysis{Encoding: EncodingGSM7, Characters: 160, Units: 160, Segments: 1},
+		},
+		{
+			name:    "gsm concatenated",
+			content: strings.Repeat("a", 161),
+			want:    ContentAnalysis{Encoding: EncodingGSM7, Characters: 161, Units: 161, Segments: 2},
+		},
+		{
+			name:    "gsm extension",
+			content: "Cost: €5 {approx}",
+			want:    ContentAnalysis{Encoding: EncodingGSM7, Characters: 17, Units: 20, Segments: 1},
+		},
+		{
+			name:    "gsm extension not split across segments",
+			content: strings.Repeat("a", 152) + "€" + strings.Repeat("a", 10),
+			want:    ContentAnalysis{Encoding: EncodingGSM7, Characters: 163, Units: 164, Segments: 2},
+		},
+		{
+			name:    "ucs2 macron",
+			content: "Kia ora, tēnā koe",
+			want:    ContentAnalysis{Encoding: EncodingUCS2, Characters: 17, Units: 17, Segments: 1, UCS2Characters: []rune{'ē', 'ā'}},
+		},
+		{
+			name:    "ucs2 emoji",
+			content: strings.Repeat("a", 69) + "😀",
+			want:    ContentAnalysis{Encoding: EncodingUCS2, Characters: 70, Units: 71, Segments: 2, UCS2Characters: []rune{'😀'}},
+		},
+	}
+	for _, test := range tests {
+		got := AnalyzeContent(test.content)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: AnalyzeContent returned %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSegmentBudgetHook(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("MobileGateway.CreateMessage should not have sent a message over budget")
+	})
+
+	client.MobileGateway.AddPreSendHook(SegmentBudgetHook(1, nil))
+	payload := &Message{Destination: "+642123456789", Content: strings.Repeat("😀", 40)}
+	if _, err := client.MobileGateway.CreateMessage(payload); err != ErrSegmentBudgetExceeded {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrSegmentBudgetExceeded)
+	}
+}
+
+func TestSegmentBudgetHook_Warn(t *testing.T) {
+	var warned ContentAnalysis
+	hook := SegmentBudgetHook(1, func(message *Message, analysis ContentAnalysis) {
+		warned = analysis
+	})
+
+	if err := hook(context.Background(), &Message{Content: strings.Repeat("a", 200)}); err != nil {
+		t.Errorf("SegmentBudgetHook returned %+v, want nil when warning", err)
+	}
+	if warned.Segments != 2 {
+		t.Errorf("SegmentBudgetHook warned with %d segments, want %d", warned.Segments, 2)
+	}
+}
@@ -0,0 +1,522 @@
+package modica
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStopKeywords contains the inbound replies that opt a number out of
+// receiving messages by default.
+var DefaultStopKeywords = []string{"STOP", "STOPALL", "UNSUBSCRIBE", "CANCEL", "END", "QUIT"}
+
+// DefaultStartKeywords contains the inbound replies that opt a number back in
+// to receiving messages by default.
+var DefaultStartKeywords = []string{"START", "UNSTOP", "SUBSCRIBE"}
+
+// suppressionCSVHeader contains the columns suppression lists are imported and
+// exported with.
+var suppressionCSVHeader = []string{"number", "reason", "suppressed_at"}
+
+// Suppression records a number that messages must not be sent to.
+type Suppression struct {
+	// Number contains the suppressed phone number.
+	Number string
+
+	// Reason contains why the number was suppressed, such as the keyword
+	// the recipient replied with.
+	Reason string
+
+	// SuppressedAt contains when the number was suppressed.
+	SuppressedAt time.Time
+}
+
+// SuppressionStore persists a suppression list. Implementations must be safe
+// for concurrent use.
+type SuppressionStore interface {
+	// Get returns the suppression for number, if it is suppressed.
+	Get(ctx context.Context, number string) (Suppression, bool, error)
+
+	// Put suppresses a number, replacing any existing suppression.
+	Put(ctx context.Context, suppression Suppression) error
+
+	// Delete removes the suppression for number, if any.
+	Delete(ctx context.Context, number string) error
+
+	// List returns every suppression, ordered by number.
+	List(ctx context.Context) ([]Suppression, error)
+}
+
+// BulkSuppressionStore is implemented by suppression stores that can suppress
+// many numbers at once more cheaply than one at a time, such as a store that
+// rewrites a file on each change. ImportCSV uses it when available.
+type BulkSuppressionStore interface {
+	SuppressionStore
+
+	// PutAll suppresses each number, replacing any existing suppressions.
+	PutAll(ctx context.Context, suppressions []Suppression) error
+}
+
+// MemorySuppressionStore keeps a suppression list in memory.
+type MemorySuppressionStore struct {
+	mu           sync.RWMutex
+	suppressions map[string]Suppression
+}
+
+// NewMemorySuppressionStore returns an empty in-memory suppression store.
+func NewMemorySuppressionStore() *MemorySuppressionStore {
+	return &MemorySuppressionStore{suppressions: make(map[string]Suppression)}
+}
+
+// Get returns the suppression for number, if it is suppressed.
+func (s *MemorySuppressionStore) Get(ctx context.Context, number string) (Suppression, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	suppression, ok := s.suppressions[number]
+	return suppression, ok, nil
+}
+
+// Put suppresses a number, replacing any existing suppression.
+func (s *MemorySuppressionStore) Put(ctx context.Context, suppression Suppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.suppressions[suppression.Number] = suppression
+	return nil
+}
+
+// PutAll suppresses each number, replacing any existing suppressions.
+func (s *MemorySuppressionStore) PutAll(ctx context.Context, suppressions []Suppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, suppression := range suppressions {
+		s.suppressions[suppression.Number] = suppression
+	}
+	return nil
+}
+
+// Delete removes the suppression for number, if any.
+func (s *MemorySuppressionStore) Delete(ctx context.Context, number string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.suppressions, number)
+	return nil
+}
+
+// List returns every suppression, ordered by number.
+func (s *MemorySuppressionStore) List(ctx context.Context) ([]Suppression, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	suppressions := make([]Suppression, 0, len(s.suppressions))
+	for _, suppression := range s.suppressions {
+		suppressions = append(suppressions, suppression)
+	}
+	sort.Slice(suppressions, func(i, j int) bool { return suppressions[i].Number < suppressions[j].Number })
+
+	return suppressions, nil
+}
+
+// FileSuppressionStore keeps a suppression list in memory, saving it to a CSV
+// file each time it changes.
+type FileSuppressionStore struct {
+	path   string
+	memory *MemorySuppressionStore
+
+	// mu serialises writes to the file.
+	mu sync.Mutex
+}
+
+// OpenFileSuppressionStore opens the suppression list saved at path, creating
+// it if it doesn't exist.
+func OpenFileSuppressionStore(path string) (*FileSuppressionStore, error) {
+	s := &FileSuppressionStore{
+		path:   path,
+		memory: NewMemorySuppressionStore(),
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	suppressions, err := readSuppressionCSV(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, suppression := range suppressions {
+		s.memory.suppressions[suppression.Number] = suppression
+	}
+
+	return s, nil
+}
+
+// Get returns the suppression for number, if it is suppressed.
+func (s *FileSuppressionStore) Get(ctx context.Context, number string) (Suppression, bool, error) {
+	return s.memory.Get(ctx, number)
+}
+
+// Put suppresses a number, replacing any existing suppression.
+func (s *FileSuppressionStore) Put(ctx context.Context, suppression Suppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory.Put(ctx, suppression)
+	return s.save(ctx)
+}
+
+// PutAll suppresses each number, replacing any existing suppressions, and
+// saves the file once.
+func (s *FileSuppressionStore) PutAll(ctx context.Context, suppressions []Suppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory.PutAll(ctx, suppressions)
+	return s.save(ctx)
+}
+
+// Delete removes the suppression for number, if any.
+func (s *FileSuppressionStore) Delete(ctx context.Context, number string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory.Delete(ctx, number)
+	return s.save(ctx)
+}
+
+// List returns every suppression, ordered by number.
+func (s *FileSuppressionStore) List(ctx context.Context) ([]Suppression, error) {
+	return s.memory.List(ctx)
+}
+
+// save atomically replaces the file with the current suppression list.
+func (s *FileSuppressionStore) save(ctx context.Context) error {
+	suppressions, _ := s.memory.List(ctx)
+
+	tmp, err := os.OpenFile(filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeSuppressionCSV(tmp, suppressions); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// SuppressionList prevents messages being sent to numbers that have opted out,
+// keeping itself up to date from the STOP and START replies recipients send.
+type SuppressionList struct {
+	store SuppressionStore
+
+	// region national numbers are interpreted as belonging to.
+	region Region
+
+	mu            sync.RWMutex
+	stopKeywords  map[string]bool
+	startKeywords map[string]bool
+	now           func() time.Time
+}
+
+// NewSuppressionList returns a suppression list backed by store, which
+// recognises the default STOP and START keywords.
+//
+// Numbers are stored and looked up in E.164 format, so a number is suppressed
+// however it is written. National numbers are interpreted as New Zealand
+// numbers unless changed with SetRegion.
+func NewSuppressionList(store SuppressionStore) *SuppressionList {
+	l := &SuppressionList{
+		store:  store,
+		region: RegionNZ,
+		now:    time.Now,
+	}
+	l.SetKeywords(DefaultStopKeywords, DefaultStartKeywords)
+
+	return l
+}
+
+// SetKeywords replaces the keywords that opt a number out of, and back in to,
+// receiving messages. Keywords are matched against the first word of an
+// inbound message, ignoring case.
+func (l *SuppressionList) SetKeywords(stop []string, start []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stopKeywords = makeKeywordSet(stop)
+	l.startKeywords = makeKeywordSet(start)
+}
+
+// SetRegion sets the region national numbers are interpreted as belonging to.
+// It should be set before the list is used, as numbers already stored aren't
+// reinterpreted.
+func (l *SuppressionList) SetRegion(region Region) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.region = region
+}
+
+// key returns number in the form it is stored in.
+func (l *SuppressionList) key(number string) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return phoneNumberKey(number, l.region)
+}
+
+func makeKeywordSet(keywords []string) map[string]bool {
+	set := make(map[string]bool, len(keywords))
+	for _, keyword := range keywords {
+		set[strings.ToUpper(strings.TrimSpace(keyword))] = true
+	}
+	return set
+}
+
+// IsSuppressed reports whether messages must not be sent to number.
+func (l *SuppressionList) IsSuppressed(ctx context.Context, number string) (bool, error) {
+	_, ok, err := l.store.Get(ctx, l.key(number))
+	return ok, err
+}
+
+// Suppress opts number out of receiving messages.
+func (l *SuppressionList) Suppress(ctx context.Context, number string, reason string) error {
+	return l.store.Put(ctx, Suppression{
+		Number:       l.key(number),
+		Reason:       reason,
+		SuppressedAt: l.now().UTC(),
+	})
+}
+
+// Unsuppress opts number back in to receiving messages.
+func (l *SuppressionList) Unsuppress(ctx context.Context, number string) error {
+	return l.store.Delete(ctx, l.key(number))
+}
+
+// HandleInbound updates the list from an inbound message, suppressing or
+// unsuppressing its source if it starts with a STOP or START keyword.
+func (l *SuppressionList) HandleInbound(ctx context.Context, message *Message) error {
+	if message == nil || message.Source == "" {
+		return nil
+	}
+
+	fields := strings.Fields(message.Content)
+	if len(fields) == 0 {
+		return nil
+	}
+	keyword := strings.ToUpper(strings.Trim(fields[0], ".!"))
+
+	l.mu.RLock()
+	stop, start := l.stopKeywords[keyword], l.startKeywords[keyword]
+	l.mu.RUnlock()
+
+	switch {
+	case stop:
+		return l.Suppress(ctx, message.Source, keyword)
+	case start:
+		return l.Unsuppress(ctx, message.Source)
+	}
+
+	return nil
+}
+
+// InboundMessageFunc returns an InboundMessageFunc that updates the list from
+// each inbound message before passing it on to next, if next is not nil.
+func (l *SuppressionList) InboundMessageFunc(next InboundMessageFunc) InboundMessageFunc {
+	return func(ctx context.Context, message *Message) error {
+		if err := l.HandleInbound(ctx, message); err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+
+		return next(ctx, message)
+	}
+}
+
+// ImportCSV suppresses each number in a CSV with number, reason and
+// suppressed_at columns, as written by ExportCSV. The header row and all but
+// the number column are optional. It returns the number of numbers imported.
+// If the list's store is a BulkSuppressionStore, the numbers are stored in a
+// single call.
+func (l *SuppressionList) ImportCSV(ctx context.Context, r io.Reader) (int, error) {
+	suppressions, err := readSuppressionCSV(r)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range suppressions {
+		suppressions[i].Number = l.key(suppressions[i].Number)
+		if suppressions[i].SuppressedAt.IsZero() {
+			suppressions[i].SuppressedAt = l.now().UTC()
+		}
+	}
+
+	if bulk, ok := l.store.(BulkSuppressionStore); ok {
+		if err := bulk.PutAll(ctx, suppressions); err != nil {
+			return 0, err
+		}
+		return len(suppressions), nil
+	}
+
+	for i, suppression := range suppressions {
+		if err := l.store.Put(ctx, suppression); err != nil {
+			return i, err
+		}
+	}
+
+	return len(suppressions), nil
+}
+
+// ExportCSV writes every suppression as CSV, with a header row.
+func (l *SuppressionList) ExportCSV(ctx context.Context, w io.Writer) error {
+	suppressions, err := l.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	return writeSuppressionCSV(w, suppressions)
+}
+
+func readSuppressionCSV(r io.Reader) ([]Suppression, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var suppressions []Suppression
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return suppressions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line == 1 && strings.EqualFold(record[0], suppressionCSVHeader[0]) {
+			continue
+		}
+		if strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		suppression := Suppression{Number: strings.TrimSpace(record[0])}
+		if len(record) > 1 {
+			suppression.Reason = record[1]
+		}
+		if len(record) > 2 && record[2] != "" {
+			suppression.SuppressedAt, err = time.Parse(time.RFC3339, record[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid suppressed_at on line %d: %v", line, err)
+			}
+		}
+		suppressions = append(suppressions, suppression)
+	}
+}
+
+func writeSuppressionCSV(w io.Writer, suppressions []Suppression) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(suppressionCSVHeader); err != nil {
+		return err
+	}
+
+	for _, suppression := range suppressions {
+		var suppressedAt string
+		if !suppression.SuppressedAt.IsZero() {
+			suppressedAt = suppression.SuppressedAt.Format(time.RFC3339)
+		}
+
+		if err := writer.Write([]string{suppression.Number, suppression.Reason, suppressedAt}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// SetSuppressionList configures the client to check destinations against list
+// before sending. Messages to suppressed destinations are rejected with
+// ErrDestinationSuppressed, and suppressed destinations are removed from
+// broadcasts. A nil list disables checking.
+func (c *Client) SetSuppressionList(list *SuppressionList) {
+	c.suppressions = list
+}
+
+// WithSuppressionList checks destinations against list before sending. See
+// Client.SetSuppressionList.
+func WithSuppressionList(list *SuppressionList) ClientOption {
+	return func(cfg *clientConfig) error {
+		cfg.suppressions = list
+		return nil
+	}
+}
+
+// checkSuppressed returns ErrDestinationSuppressed if the client's suppression
+// list contains destination.
+func (m MobileGatewayService) checkSuppressed(ctx context.Context, destination string) error {
+	if m.client.suppressions == nil {
+		return nil
+	}
+
+	suppressed, err := m.client.suppressions.IsSuppressed(ctx, destination)
+	if err != nil {
+		return err
+	}
+	if suppressed {
+		return fmt.Errorf("%w (%s)", ErrDestinationSuppressed, destination)
+	}
+
+	return nil
+}
+
+// filterSuppressed removes suppressed destinations, reporting them as failed
+// broadcast responses.
+func (m MobileGatewayService) filterSuppressed(ctx context.Context, destinations []string) ([]string, []BroadcastResponse, error) {
+	if m.client.suppressions == nil {
+		return destinations, nil, nil
+	}
+
+	var suppressed []BroadcastResponse
+	allowed := make([]string, 0, len(destinations))
+	for _, destination := range destinations {
+		err := m.checkSuppressed(ctx, destination)
+		switch {
+		case errors.Is(err, ErrDestinationSuppressed):
+			suppressed = append(suppressed, BroadcastResponse{
+				Status:      MessageStatusFailure,
+				Message:     "Destination suppressed (" + destination + ")",
+				Destination: destination,
+			})
+		case err != nil:
+			return nil, nil, err
+		default:
+			allowed = append(allowed, destination)
+		}
+	}
+
+	return allowed, suppressed, nil
+}
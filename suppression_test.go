@@ -0,0 +1,258 @@
+package modica
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuppressionList_HandleInbound(t *testing.T) {
+	ctx := context.Background()
+	list := NewSuppressionList(NewMemorySuppressionStore())
+
+	tests := []struct {
+		content    string
+		suppressed bool
+	}{
+		{content: "stop", suppressed: true},
+		{content: "Hello", suppressed: true},
+		{content: "Start please", suppressed: false},
+		{content: "STOP.", suppressed: true},
+		{content: "don't stop", suppressed: true},
+		{content: "UNSTOP", suppressed: false},
+	}
+	for _, test := range tests {
+		inbound := list.InboundMessageFunc(nil)
+		if err := inbound(ctx, &Message{Source: "+64211234567", Content: test.content}); err != nil {
+			t.Fatalf("%q: SuppressionList.HandleInbound returned error: %v", test.content, err)
+		}
+
+		if got, _ := list.IsSuppressed(ctx, "+64211234567"); got != test.suppressed {
+			t.Errorf("%q: SuppressionList.IsSuppressed returned %t, want %t", test.content, got, test.suppressed)
+		}
+	}
+}
+
+func TestSuppressionList_NumberFormats(t *testing.T) {
+	ctx := context.Background()
+	list := NewSuppressionList(NewMemorySuppressionStore())
+
+	if err := list.HandleInbound(ctx, &Message{Source: "+64211234567", Content: "STOP"}); err != nil {
+		t.Fatalf("SuppressionList.HandleInbound returned error: %v", err)
+	}
+	for _, number := range []string{"+64211234567", "0211234567", "021 123 4567", "+64 (0)21-123-4567", "0064211234567"} {
+		if got, _ := list.IsSuppressed(ctx, number); !got {
+			t.Errorf("SuppressionList.IsSuppressed(%q) returned %t, want %t", number, got, true)
+		}
+	}
+
+	if _, err := list.ImportCSV(ctx, strings.NewReader("0412 345 678\n")); err != nil {
+		t.Fatalf("SuppressionList.ImportCSV returned error: %v", err)
+	}
+	if got, _ := list.IsSuppressed(ctx, "+61412345678"); got {
+		t.Error("SuppressionList.IsSuppressed matched a New Zealand number to an Australian one")
+	}
+	list.SetRegion(RegionAU)
+	if err := list.Suppress(ctx, "0412 345 678", "manual"); err != nil {
+		t.Fatalf("SuppressionList.Suppress returned error: %v", err)
+	}
+	if got, _ := list.IsSuppressed(ctx, "+61412345678"); !got {
+		t.Errorf("SuppressionList.IsSuppressed(%q) returned %t, want %t", "+61412345678", got, true)
+	}
+
+	if err := list.Unsuppress(ctx, "+64 21-123-4567"); err != nil {
+		t.Fatalf("SuppressionList.Unsuppress returned error: %v", err)
+	}
+	if got, _ := list.IsSuppressed(ctx, "+64211234567"); got {
+		t.Errorf("SuppressionList.IsSuppressed(%q) returned %t, want %t", "+64211234567", got, false)
+	}
+}
+
+func TestSuppressionList_SetKeywords(t *testing.T) {
+	ctx := context.Background()
+	list := NewSuppressionList(NewMemorySuppressionStore())
+	list.SetKeywords([]string{"kati"}, []string{"tīmata"})
+
+	list.HandleInbound(ctx, &Message{Source: "+64211234567", Content: "STOP"})
+	if got, _ := list.IsSuppressed(ctx, "+64211234567"); got {
+		t.Error("SuppressionList suppressed a number for a keyword that was replaced")
+	}
+
+	list.HandleInbound(ctx, &Message{Source: "+64211234567", Content: "Kati"})
+	if got, _ := list.IsSuppressed(ctx, "+64211234567"); !got {
+		t.Error("SuppressionList didn't suppress a number for a configured keyword")
+	}
+}
+
+func TestSuppressionList_CSV(t *testing.T) {
+	ctx := context.Background()
+	list := NewSuppressionList(NewMemorySuppressionStore())
+	list.now = func() time.Time { return time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC) }
+
+	csv := "number,reason,suppressed_at\n+64211111111,STOP,2017-01-02T03:04:05Z\n+64212222222\n"
+	n, err := list.ImportCSV(ctx, strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("SuppressionList.ImportCSV returned error: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("SuppressionList.ImportCSV imported %d numbers, want %d", n, 2)
+	}
+
+	var buf bytes.Buffer
+	if err := list.ExportCSV(ctx, &buf); err != nil {
+		t.Fatalf("SuppressionList.ExportCSV returned error: %v", err)
+	}
+	want := "number,reason,suppressed_at\n+64211111111,STOP,2017-01-02T03:04:05Z\n+64212222222,,2017-05-05T10:00:00Z\n"
+	if got := buf.String(); got != want {
+		t.Errorf("SuppressionList.ExportCSV wrote %q, want %q", got, want)
+	}
+
+	if _, err := list.ImportCSV(ctx, strings.NewReader("+64211111111,STOP,yesterday\n")); err == nil {
+		t.Error("SuppressionList.ImportCSV should reject an invalid suppressed_at")
+	}
+}
+
+func TestFileSuppressionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modica-suppression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "suppressions.csv")
+	ctx := context.Background()
+
+	store, err := OpenFileSuppressionStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileSuppressionStore returned error: %v", err)
+	}
+	suppression := Suppression{Number: "+64211111111", Reason: "STOP", SuppressedAt: time.Date(2017, 5, 5, 10, 0, 0, 0, time.UTC)}
+	store.Put(ctx, suppression)
+	store.Put(ctx, Suppression{Number: "+64212222222"})
+	store.Delete(ctx, "+64212222222")
+
+	reopened, err := OpenFileSuppressionStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileSuppressionStore returned error: %v", err)
+	}
+	got, err := reopened.List(ctx)
+	if err != nil {
+		t.Fatalf("FileSuppressionStore.List returned error: %v", err)
+	}
+	if want := []Suppression{suppression}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FileSuppressionStore.List returned %+v, want %+v", got, want)
+	}
+}
+
+func TestMobileGatewayService_Suppressed(t *testing.T) {
+	client, mux, _, teardown := setup()
+	defer teardown()
+
+	list := NewSuppressionList(NewMemorySuppressionStore())
+	list.Suppress(context.Background(), "+64211111111", "STOP")
+	client.SetSuppressionList(list)
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("MobileGateway.CreateMessage sent a message to a suppressed destination")
+	})
+	mux.HandleFunc("/messages/broadcast", func(w http.ResponseWriter, r *http.Request) {
+		var broadcast BroadcastMessage
+		json.NewDecoder(r.Body).Decode(&broadcast)
+		if want := []string{"+64212222222"}; !reflect.DeepEqual(broadcast.Destinations, want) {
+			t.Errorf("MobileGateway.CreateBroadcastMessage sent to %v, want %v", broadcast.Destinations, want)
+		}
+		w.Write([]byte(`[{"status":"success","message":null,"destination":"+64212222222","id":1}]`))
+	})
+
+	_, err := client.MobileGateway.CreateMessage(&Message{Destination: "+64211111111", Content: "Hello"})
+	if !errors.Is(err, ErrDestinationSuppressed) {
+		t.Errorf("MobileGateway.CreateMessage returned %+v, want %+v", err, ErrDestinationSuppressed)
+	}
+
+	got, err := client.MobileGateway.CreateBroadcastMessage(&BroadcastMessage{
+		Destinations: []string{"+64211111111", "+64212222222"},
+		Message:      Message{Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatalf("MobileGateway.CreateBroadcastMessage returned error: %v", err)
+	}
+	want := []BroadcastResponse{
+		{Status: MessageStatusSuccess, Destination: "+64212222222", ID: 1},
+		{Status: MessageStatusFailure, Message: "Destination suppressed (+64211111111)", Destination: "+64211111111"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MobileGateway.CreateBroadcastMessage returned %+v, want %+v", got, want)
+	}
+}
+
+func TestFileSuppressionStore_IsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't enforced on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "modica-suppression")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "suppressions.csv")
+
+	store, err := OpenFileSuppressionStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileSuppressionStore returned error: %v", err)
+	}
+	store.Put(context.Background(), Suppression{Number: "+64211111111"})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode&0077 != 0 {
+		t.Errorf("FileSuppressionStore file has mode %v, want it readable only by its owner", mode)
+	}
+}
+
+// countingSuppressionStore counts the calls made to store suppressions.
+type countingSuppressionStore struct {
+	*MemorySuppressionStore
+	puts, putAlls int
+}
+
+func (s *countingSuppressionStore) Put(ctx context.Context, suppression Suppression) error {
+	s.puts++
+	return s.MemorySuppressionStore.Put(ctx, suppression)
+}
+
+func (s *countingSuppressionStore) PutAll(ctx context.Context, suppressions []Suppression) error {
+	s.putAlls++
+	return s.MemorySuppressionStore.PutAll(ctx, suppressions)
+}
+
+func TestSuppressionList_ImportCSV_Bulk(t *testing.T) {
+	ctx := context.Background()
+	store := &countingSuppressionStore{MemorySuppressionStore: NewMemorySuppressionStore()}
+	list := NewSuppressionList(store)
+
+	n, err := list.ImportCSV(ctx, strings.NewReader("+64211111111\n+64212222222\n+64213333333\n"))
+	if err != nil {
+		t.Fatalf("SuppressionList.ImportCSV returned error: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("SuppressionList.ImportCSV imported %d numbers, want %d", n, 3)
+	}
+	if store.puts != 0 || store.putAlls != 1 {
+		t.Errorf("SuppressionList.ImportCSV made %d puts and %d bulk puts, want a single bulk put", store.puts, store.putAlls)
+	}
+	if suppressed, _ := list.IsSuppressed(ctx, "+64213333333"); !suppressed {
+		t.Error("SuppressionList.IsSuppressed returned false for an imported number")
+	}
+}